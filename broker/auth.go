@@ -0,0 +1,317 @@
+package broker
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+// AuthHook authenticates clients and checks ACLs against the ledger stored in the database. The ruleset is
+// reloaded whenever the ledger is edited, so changes apply to new connections without a restart.
+type AuthHook struct {
+	mqtt.HookBase
+	rules atomic.Pointer[ruleset]
+
+	mu sync.Mutex
+	db *sql.DB
+}
+
+type ruleset struct {
+	users []db.BrokerUser
+	acl   auth.ACLRules
+}
+
+func (h *AuthHook) ID() string {
+	return "auth-db-ledger"
+}
+
+func (h *AuthHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+	}, []byte{b})
+}
+
+// Init takes the ledger from the broker configuration, if any. It is used until a database is attached.
+func (h *AuthHook) Init(config any) error {
+	if config == nil {
+		h.rules.Store(&ruleset{})
+		return nil
+	}
+
+	ledger, ok := config.(*auth.Ledger)
+	if !ok {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	users, acl, err := LedgerRules(ledger)
+	if err != nil {
+		return err
+	}
+
+	rules := &ruleset{acl: toACLRules(acl)}
+	for _, user := range users {
+		user.BrokerUser.PasswordHash, err = db.HashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		rules.users = append(rules.users, user.BrokerUser)
+	}
+	h.rules.Store(rules)
+
+	return nil
+}
+
+// SetDB switches the hook to the ledger stored in the database. On first use, when the stored ledger is empty,
+// the configured ledger is imported so that nothing changes for connecting clients.
+func (h *AuthHook) SetDB(dbConn *sql.DB, configured *auth.Ledger) error {
+	h.mu.Lock()
+	h.db = dbConn
+	h.mu.Unlock()
+
+	if configured != nil {
+		empty, err := db.BrokerLedgerEmpty(dbConn)
+		if err != nil {
+			return err
+		}
+
+		if empty {
+			users, acl, err := LedgerRules(configured)
+			if err != nil {
+				return err
+			}
+			if err := db.ImportBrokerLedger(dbConn, users, acl, false); err != nil {
+				return fmt.Errorf("failed to import the configured ledger: %w", err)
+			}
+			h.Log.Info("Imported the configured auth ledger into the database", "users", len(users), "acl", len(acl))
+		}
+	}
+
+	return h.Reload()
+}
+
+// MergeLedger adds the rules of ledger that previous, the ledger it replaces in the configuration, did not
+// have. They are evaluated after the stored ones, and rules added through the API or left out of ledger are
+// kept. It returns the number of users and ACL rules added.
+func (h *AuthHook) MergeLedger(previous, ledger *auth.Ledger) (int, int, error) {
+	if ledger == nil {
+		ledger = &auth.Ledger{}
+	}
+	if previous == nil {
+		previous = &auth.Ledger{}
+	}
+
+	users, acl, err := LedgerRules(ledger)
+	if err != nil {
+		return 0, 0, err
+	}
+	// A previous ledger that failed to convert was never imported, so none of its rules count as present
+	previousUsers, previousACL, _ := LedgerRules(previous)
+	users, acl = newRules(users, previousUsers), newRules(acl, previousACL)
+
+	h.mu.Lock()
+	dbConn := h.db
+	h.mu.Unlock()
+
+	if dbConn == nil {
+		// Without a database the configured ledger is all there is
+		return len(users), len(acl), h.Init(ledger)
+	}
+
+	if len(users)+len(acl) == 0 {
+		return 0, 0, nil
+	}
+	if err := db.ImportBrokerLedger(dbConn, users, acl, false); err != nil {
+		return 0, 0, fmt.Errorf("failed to import the configured ledger: %w", err)
+	}
+
+	return len(users), len(acl), h.Reload()
+}
+
+// newRules returns the rules that are not in previous.
+func newRules[T any](rules, previous []T) []T {
+	var added []T
+	for _, rule := range rules {
+		found := false
+		for _, p := range previous {
+			if reflect.DeepEqual(rule, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, rule)
+		}
+	}
+	return added
+}
+
+// Reload reads the ledger from the database again.
+func (h *AuthHook) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.db == nil {
+		return nil
+	}
+
+	users, err := db.ListBrokerUsers(h.db)
+	if err != nil {
+		return err
+	}
+
+	acl, err := db.ListBrokerACL(h.db)
+	if err != nil {
+		return err
+	}
+
+	h.rules.Store(&ruleset{users: users, acl: toACLRules(acl)})
+	return nil
+}
+
+// OnConnectAuthenticate applies the first auth rule matching the client. Clients matching no rule are refused.
+func (h *AuthHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	for _, user := range h.rules.Load().users {
+		if auth.RString(user.Client).Matches(cl.ID) &&
+			auth.RString(user.Username).Matches(string(cl.Properties.Username)) &&
+			auth.RString(user.Remote).Matches(cl.Net.Remote) &&
+			user.CheckPassword(string(pk.Connect.Password)) {
+			if !user.Allow {
+				h.Log.Info("client refused by auth rule", "client", cl.ID, "username", string(cl.Properties.Username), "remote", cl.Net.Remote)
+			}
+			return user.Allow
+		}
+	}
+
+	h.Log.Info("client failed authentication check", "client", cl.ID, "username", string(cl.Properties.Username), "remote", cl.Net.Remote)
+	return false
+}
+
+// OnACLCheck follows mochi's ledger semantics: the first matching rule decides, and topics no rule covers are allowed.
+func (h *AuthHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	return aclOk(h.rules.Load().acl, cl, topic, write)
+}
+
+// aclOk checks the first rule matching the client whose filters cover topic. Filters match level by level,
+// so unlike subscriptions a leading wildcard covers '$' topics too, and a deny-all rule still denies $SYS.
+func aclOk(rules auth.ACLRules, cl *mqtt.Client, topic string, write bool) bool {
+	for _, rule := range rules {
+		if !rule.Client.Matches(cl.ID) || !rule.Username.Matches(string(cl.Properties.Username)) || !rule.Remote.Matches(cl.Net.Remote) {
+			continue
+		}
+		if len(rule.Filters) == 0 {
+			return true
+		}
+
+		covered := false
+		for filter, access := range rule.Filters {
+			if !mqtttopic.MatchLevels(string(filter), topic) {
+				continue
+			}
+			if (write && (access == auth.WriteOnly || access == auth.ReadWrite)) || (!write && (access == auth.ReadOnly || access == auth.ReadWrite)) {
+				return true
+			}
+			covered = true
+		}
+		if covered {
+			return false
+		}
+	}
+	return true
+}
+
+func toACLRules(rules []db.BrokerACLRule) auth.ACLRules {
+	acl := make(auth.ACLRules, 0, len(rules))
+	for _, rule := range rules {
+		filters := make(auth.Filters, len(rule.Filters))
+		for filter, access := range rule.Filters {
+			filters[auth.RString(filter)] = auth.Access(access)
+		}
+		acl = append(acl, auth.ACLRule{
+			Client:   auth.RString(rule.Client),
+			Username: auth.RString(rule.Username),
+			Remote:   auth.RString(rule.Remote),
+			Filters:  filters,
+		})
+	}
+	return acl
+}
+
+// LedgerRules converts a mochi-style ledger into stored rules. Entries of the users map come first, as mochi
+// checks them before the auth and ACL rules.
+func LedgerRules(ledger *auth.Ledger) ([]db.BrokerLedgerUser, []db.BrokerACLRule, error) {
+	var users []db.BrokerLedgerUser
+	var acl []db.BrokerACLRule
+
+	names := make([]string, 0, len(ledger.Users))
+	for name := range ledger.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		user := ledger.Users[name]
+		username := string(user.Username)
+		if username == "" {
+			username = name
+		}
+
+		users = append(users, db.BrokerLedgerUser{
+			BrokerUser: db.BrokerUser{Username: username, Allow: !user.Disallow},
+			Password:   string(user.Password),
+		})
+
+		if len(user.ACL) > 0 {
+			acl = append(acl, db.BrokerACLRule{Username: username, Filters: toFilterMap(user.ACL)})
+		}
+	}
+
+	for i, rule := range ledger.Auth {
+		if rule.Password == "*" {
+			rule.Password = ""
+		} else if strings.Contains(string(rule.Password), "*") {
+			return nil, nil, fmt.Errorf("auth[%d].password: wildcard passwords cannot be hashed, leave the password empty to accept any", i)
+		}
+
+		users = append(users, db.BrokerLedgerUser{
+			BrokerUser: db.BrokerUser{
+				Client:   string(rule.Client),
+				Username: string(rule.Username),
+				Remote:   string(rule.Remote),
+				Allow:    rule.Allow,
+			},
+			Password: string(rule.Password),
+		})
+	}
+
+	for _, rule := range ledger.ACL {
+		acl = append(acl, db.BrokerACLRule{
+			Client:   string(rule.Client),
+			Username: string(rule.Username),
+			Remote:   string(rule.Remote),
+			Filters:  toFilterMap(rule.Filters),
+		})
+	}
+
+	return users, acl, nil
+}
+
+func toFilterMap(filters auth.Filters) map[string]int {
+	filterMap := make(map[string]int, len(filters))
+	for filter, access := range filters {
+		filterMap[string(filter)] = int(access)
+	}
+	return filterMap
+}
@@ -0,0 +1,204 @@
+package broker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/hooks/debug"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// Broker is the embedded mochi server along with the hooks the simulator manages at runtime.
+type Broker struct {
+	Server *mqtt.Server
+
+	// Auth is nil when the broker allows all clients
+	Auth *AuthHook
+
+	Inspector *InspectorHook
+
+	ClientEvents *ClientsHook
+
+	TopicStats *TopicStatsHook
+
+	storage  mqtt.Hook
+	gate     *wipeGate
+	logLevel *slog.LevelVar
+	config   *Config
+}
+
+// New builds the embedded broker from its configuration, with its hooks and listeners attached.
+func New(cfg *Config) (*Broker, error) {
+	opts := cfg.Options
+
+	// The publisher sends through the inline client, so it is always on
+	opts.InlineClient = true
+
+	// Same handler as mochi's default logger, with a level that can change at runtime
+	logLevel := new(slog.LevelVar)
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	}
+
+	b := &Broker{Server: mqtt.New(&opts), logLevel: logLevel, config: cfg}
+
+	if err := b.addHooks(cfg.Hooks); err != nil {
+		return nil, err
+	}
+
+	for i, l := range cfg.Listeners {
+		listener, err := newListener(b.Server, l)
+		if err != nil {
+			return nil, fmt.Errorf("listeners[%d].tls: %w", i, err)
+		}
+		if err := b.Server.AddListener(listener); err != nil {
+			return nil, fmt.Errorf("listeners[%d]: failed to add %s listener %q on %s: %w", i, l.Type, l.ID, l.Address, err)
+		}
+	}
+
+	return b, nil
+}
+
+// SetDB moves broker state that is managed through the API, such as the auth ledger, to the database.
+func (b *Broker) SetDB(dbConn *sql.DB) error {
+	if b.Auth == nil {
+		return nil
+	}
+
+	if err := b.Auth.SetDB(dbConn, b.config.Hooks.Auth.Ledger); err != nil {
+		return fmt.Errorf("hooks.auth: %w", err)
+	}
+
+	return nil
+}
+
+// MergeLedger imports the rules that ledger adds to previous, the configured ledger it replaces.
+func (b *Broker) MergeLedger(previous, ledger *auth.Ledger) (int, int, error) {
+	if b.Auth == nil {
+		return 0, 0, errors.New("the broker allows all clients, so the ledger takes no effect")
+	}
+
+	return b.Auth.MergeLedger(previous, ledger)
+}
+
+// SetLogLevel changes the broker log level: debug, info, warn or error. An empty level means info.
+func (b *Broker) SetLogLevel(level string) error {
+	var l slog.Level
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+		}
+	}
+
+	b.logLevel.Set(l)
+	return nil
+}
+
+func (b *Broker) addHooks(hooks HooksConfig) error {
+	server := b.Server
+
+	switch {
+	case hooks.Auth == nil:
+		server.Log.Warn("No auth hook configured, allowing all clients")
+		if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+			return fmt.Errorf("hooks.auth: %w", err)
+		}
+	case hooks.Auth.AllowAll:
+		if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+			return fmt.Errorf("hooks.auth: %w", err)
+		}
+	default:
+		b.Auth = new(AuthHook)
+		var ledger interface{}
+		if hooks.Auth.Ledger != nil {
+			ledger = hooks.Auth.Ledger
+		}
+		if err := server.AddHook(b.Auth, ledger); err != nil {
+			return fmt.Errorf("hooks.auth: %w", err)
+		}
+	}
+
+	usernameFrom := make(map[string]string)
+	for _, l := range b.config.Listeners {
+		if l.TLS != nil && l.TLS.UsernameFrom != "" {
+			usernameFrom[l.ID] = l.TLS.UsernameFrom
+		}
+	}
+	if len(usernameFrom) > 0 {
+		if err := server.AddHook(new(CertIdentityHook), usernameFrom); err != nil {
+			return fmt.Errorf("listeners: %w", err)
+		}
+	}
+
+	b.Inspector = new(InspectorHook)
+	if err := server.AddHook(b.Inspector, nil); err != nil {
+		return fmt.Errorf("inspector: %w", err)
+	}
+
+	b.ClientEvents = new(ClientsHook)
+	if err := server.AddHook(b.ClientEvents, nil); err != nil {
+		return fmt.Errorf("clients: %w", err)
+	}
+
+	b.TopicStats = new(TopicStatsHook)
+	if err := server.AddHook(b.TopicStats, nil); err != nil {
+		return fmt.Errorf("topic stats: %w", err)
+	}
+
+	if hooks.Debug != nil && hooks.Debug.Enable {
+		if err := server.AddHook(new(debug.Hook), hooks.Debug); err != nil {
+			return fmt.Errorf("hooks.debug: %w", err)
+		}
+	}
+
+	if hooks.Storage != nil {
+		hook, opts, err := hooks.Storage.newHook()
+		if err != nil {
+			return fmt.Errorf("hooks.storage: %w", err)
+		}
+		if hook != nil {
+			if err := server.AddHook(hook, opts); err != nil {
+				return fmt.Errorf("hooks.storage.%s: %w", hooks.Storage.Type, err)
+			}
+			b.storage = hook
+		}
+	}
+
+	// Last, so that it sees clients disconnect once their session is stored
+	b.gate = new(wipeGate)
+	if err := server.AddHook(b.gate, nil); err != nil {
+		return fmt.Errorf("wipe gate: %w", err)
+	}
+
+	return nil
+}
+
+func newListener(server *mqtt.Server, l ListenerConfig) (listeners.Listener, error) {
+	conf := listeners.Config{Type: l.Type, ID: l.ID, Address: l.Address}
+
+	if l.TLS != nil {
+		tlsConfig, err := l.TLS.load()
+		if err != nil {
+			return nil, err
+		}
+		conf.TLSConfig = tlsConfig
+	}
+
+	switch l.Type {
+	case listeners.TypeWS:
+		return listeners.NewWebsocket(conf), nil
+	case listeners.TypeUnix:
+		return listeners.NewUnixSock(conf), nil
+	case listeners.TypeHealthCheck:
+		return listeners.NewHTTPHealthCheck(conf), nil
+	case listeners.TypeSysInfo:
+		return listeners.NewHTTPStats(conf, server.Info), nil
+	default:
+		return listeners.NewTCP(conf), nil
+	}
+}
@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Events sent by ClientsHook.
+const (
+	EventClientConnected    = "client_connected"
+	EventClientDisconnected = "client_disconnected"
+)
+
+var (
+	ErrClientNotFound     = errors.New("client not found")
+	ErrClientNotConnected = errors.New("client is not connected")
+)
+
+// ClientInfo describes a client of the broker, connected or with a session kept after it disconnected.
+type ClientInfo struct {
+	ID              string             `json:"id"`
+	Username        string             `json:"username"`
+	Remote          string             `json:"remote"`
+	Listener        string             `json:"listener"`
+	ProtocolVersion byte               `json:"protocol_version"`
+	Keepalive       uint16             `json:"keepalive"`
+	Clean           bool               `json:"clean"`
+	Connected       bool               `json:"connected"`
+	ConnectedAt     *time.Time         `json:"connected_at,omitempty"`
+	Subscriptions   []SubscriptionInfo `json:"subscriptions"`
+	Inflight        int                `json:"inflight"`
+	Error           string             `json:"error,omitempty"`
+}
+
+type SubscriptionInfo struct {
+	Filter            string `json:"filter"`
+	QoS               byte   `json:"qos"`
+	NoLocal           bool   `json:"no_local,omitempty"`
+	RetainAsPublished bool   `json:"retain_as_published,omitempty"`
+}
+
+// ClientsHook keeps when clients connected, and hands connects and disconnects to a sink, such as the WS hub.
+type ClientsHook struct {
+	mqtt.HookBase
+	connectedAt sync.Map
+	sink        atomic.Pointer[func(event string, info ClientInfo)]
+}
+
+func (h *ClientsHook) ID() string {
+	return "clients-registry"
+}
+
+func (h *ClientsHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnSessionEstablished,
+		mqtt.OnDisconnect,
+	}, []byte{b})
+}
+
+// SetSink sets the function receiving client events.
+func (h *ClientsHook) SetSink(sink func(event string, info ClientInfo)) {
+	h.sink.Store(&sink)
+}
+
+func (h *ClientsHook) OnSessionEstablished(cl *mqtt.Client, pk packets.Packet) {
+	h.connectedAt.Store(cl, time.Now())
+	h.emit(EventClientConnected, cl, nil)
+}
+
+func (h *ClientsHook) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	// The reason the broker stopped the client beats the read error that followed
+	if cause := cl.StopCause(); cause != nil {
+		err = cause
+	}
+	h.emit(EventClientDisconnected, cl, err)
+	h.connectedAt.Delete(cl)
+}
+
+func (h *ClientsHook) emit(event string, cl *mqtt.Client, err error) {
+	sink := h.sink.Load()
+	if sink == nil || cl.Net.Inline {
+		return
+	}
+
+	info := h.info(cl)
+	if event == EventClientDisconnected {
+		info.Connected = false
+		if err != nil {
+			info.Error = err.Error()
+		}
+	}
+	(*sink)(event, info)
+}
+
+func (h *ClientsHook) info(cl *mqtt.Client) ClientInfo {
+	info := ClientInfo{
+		ID:              cl.ID,
+		Username:        string(cl.Properties.Username),
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Keepalive:       cl.State.Keepalive,
+		Clean:           cl.Properties.Clean,
+		Connected:       !cl.Closed(),
+		Subscriptions:   []SubscriptionInfo{},
+		Inflight:        cl.State.Inflight.Len(),
+	}
+
+	if at, ok := h.connectedAt.Load(cl); ok {
+		t := at.(time.Time)
+		info.ConnectedAt = &t
+	}
+
+	for _, sub := range cl.State.Subscriptions.GetAll() {
+		info.Subscriptions = append(info.Subscriptions, SubscriptionInfo{
+			Filter:            sub.Filter,
+			QoS:               sub.Qos,
+			NoLocal:           sub.NoLocal,
+			RetainAsPublished: sub.RetainAsPublished,
+		})
+	}
+	sort.Slice(info.Subscriptions, func(i, j int) bool { return info.Subscriptions[i].Filter < info.Subscriptions[j].Filter })
+
+	return info
+}
+
+// Clients lists the clients of the broker, sorted by ID. The inline client is left out.
+func (b *Broker) Clients() []ClientInfo {
+	clients := []ClientInfo{}
+	for _, cl := range b.Server.Clients.GetAll() {
+		if !cl.Net.Inline {
+			clients = append(clients, b.ClientEvents.info(cl))
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// Client returns the client with the given ID.
+func (b *Broker) Client(id string) (ClientInfo, error) {
+	cl, ok := b.Server.Clients.Get(id)
+	if !ok || cl.Net.Inline {
+		return ClientInfo{}, fmt.Errorf("%w: %s", ErrClientNotFound, id)
+	}
+	return b.ClientEvents.info(cl), nil
+}
+
+// disconnectCodes are the reason codes a server may send in a DISCONNECT packet.
+var disconnectCodes = []packets.Code{
+	packets.CodeDisconnect,
+	packets.ErrUnspecifiedError,
+	packets.ErrMalformedPacket,
+	packets.ErrProtocolViolation,
+	packets.ErrImplementationSpecificError,
+	packets.ErrNotAuthorized,
+	packets.ErrServerBusy,
+	packets.ErrServerShuttingDown,
+	packets.ErrKeepAliveTimeout,
+	packets.ErrSessionTakenOver,
+	packets.ErrTopicFilterInvalid,
+	packets.ErrTopicNameInvalid,
+	packets.ErrReceiveMaximum,
+	packets.ErrTopicAliasInvalid,
+	packets.ErrPacketTooLarge,
+	packets.ErrMessageRateTooHigh,
+	packets.ErrQuotaExceeded,
+	packets.ErrAdministrativeAction,
+	packets.ErrPayloadFormatInvalid,
+	packets.ErrRetainNotSupported,
+	packets.ErrQosNotSupported,
+	packets.ErrUseAnotherServer,
+	packets.ErrServerMoved,
+	packets.ErrSharedSubscriptionsNotSupported,
+	packets.ErrConnectionRateExceeded,
+	packets.ErrMaxConnectTime,
+	packets.ErrSubscriptionIdentifiersNotSupported,
+	packets.ErrWildcardSubscriptionsNotSupported,
+}
+
+// DisconnectCode returns the DISCONNECT reason code with the given value.
+func DisconnectCode(code byte) (packets.Code, error) {
+	for _, c := range disconnectCodes {
+		if c.Code == code {
+			return c, nil
+		}
+	}
+	return packets.Code{}, fmt.Errorf("0x%02x is not a disconnect reason code", code)
+}
+
+// Disconnect closes the connection of a client, sending it the reason code to v5 clients.
+func (b *Broker) Disconnect(id string, code packets.Code) (ClientInfo, error) {
+	cl, ok := b.Server.Clients.Get(id)
+	if !ok || cl.Net.Inline {
+		return ClientInfo{}, fmt.Errorf("%w: %s", ErrClientNotFound, id)
+	}
+	if cl.Closed() {
+		return ClientInfo{}, fmt.Errorf("%w: %s", ErrClientNotConnected, id)
+	}
+
+	info := b.ClientEvents.info(cl)
+
+	// mochi returns error codes it disconnected with as errors
+	if err := b.Server.DisconnectClient(cl, code); err != nil && !errors.Is(err, code) {
+		return info, fmt.Errorf("failed to disconnect client %s: %w", id, err)
+	}
+
+	info.Connected = false
+	return info, nil
+}
@@ -0,0 +1,336 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/hooks/debug"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	server_config "mqtt-mochi-server/config"
+)
+
+const defaultConfigFile = "config.json"
+
+// Config describes the embedded broker: its listeners, hooks and server options. It follows the layout
+// of config.json.
+type Config struct {
+	Listeners []ListenerConfig `json:"listeners"`
+	Hooks     HooksConfig      `json:"hooks"`
+	Options   mqtt.Options     `json:"options"`
+}
+
+type ListenerConfig struct {
+	Type    string     `json:"type"`
+	ID      string     `json:"id"`
+	Address string     `json:"address"`
+	TLS     *TLSConfig `json:"tls"`
+}
+
+type HooksConfig struct {
+	Auth    *AuthHookConfig `json:"auth"`
+	Debug   *debug.Options  `json:"debug"`
+	Storage *StorageConfig  `json:"storage"`
+}
+
+type AuthHookConfig struct {
+	AllowAll bool         `json:"allow_all"`
+	Ledger   *auth.Ledger `json:"ledger"`
+}
+
+var listenerTypes = []string{listeners.TypeTCP, listeners.TypeWS, listeners.TypeUnix, listeners.TypeHealthCheck, listeners.TypeSysInfo}
+
+// DefaultConfig is used when no broker configuration is provided. It matches what the simulator
+// has always listened on, with its auth ledger managed through the API and allowing local clients.
+func DefaultConfig() *Config {
+	return &Config{
+		Listeners: []ListenerConfig{
+			{Type: listeners.TypeTCP, ID: "t1", Address: ":1883"},
+			{Type: listeners.TypeWS, ID: "ws1", Address: ":1885"},
+		},
+		Hooks: HooksConfig{
+			Auth: &AuthHookConfig{Ledger: defaultLedger()},
+		},
+		Options: mqtt.Options{
+			Capabilities: mqtt.NewDefaultServerCapabilities(),
+			InlineClient: true,
+		},
+	}
+}
+
+// defaultLedger allows local clients, as the simulator always has, until rules are added through the API.
+func defaultLedger() *auth.Ledger {
+	return &auth.Ledger{
+		Auth: auth.AuthRules{
+			{Remote: "127.0.0.1:*", Allow: true},
+			{Remote: "localhost:*", Allow: true},
+			{Remote: "[::1]:*", Allow: true},
+		},
+	}
+}
+
+// LoadConfig reads the broker configuration. A "broker" section in mqtt_sender_config.json takes precedence,
+// then the file named by mqtt_broker.config_file (config.json by default). When neither exists the
+// DefaultConfig is used. mqtt_broker.tcp_address then overrides the plain TCP listener address.
+func LoadConfig(cfg *server_config.Config) (*Config, string, error) {
+	brokerConfig, source, err := loadConfig(cfg)
+	if err != nil || cfg.MQTT.Tcp_Address == "" {
+		return brokerConfig, source, err
+	}
+
+	setTCPAddress(brokerConfig, cfg.MQTT.Tcp_Address)
+	if err := brokerConfig.Validate(); err != nil {
+		return nil, source, fmt.Errorf("invalid broker configuration in %s with mqtt_broker.tcp_address %s: %w", source, cfg.MQTT.Tcp_Address, err)
+	}
+
+	return brokerConfig, source, nil
+}
+
+// setTCPAddress moves the first plain TCP listener to address, or adds one when there is none.
+func setTCPAddress(c *Config, address string) {
+	for i, l := range c.Listeners {
+		if l.Type == listeners.TypeTCP && l.TLS == nil {
+			c.Listeners[i].Address = address
+			return
+		}
+	}
+
+	c.Listeners = append(c.Listeners, ListenerConfig{Type: listeners.TypeTCP, ID: "tcp", Address: address})
+}
+
+func loadConfig(cfg *server_config.Config) (*Config, string, error) {
+	if len(cfg.Broker_Section) > 0 {
+		source := cfg.Main_config.ConfigFileUsed() + "#broker"
+		brokerConfig, err := ParseConfig(cfg.Broker_Section)
+		if err != nil {
+			return nil, source, fmt.Errorf("invalid broker configuration in %s: %w", source, err)
+		}
+		return brokerConfig, source, nil
+	}
+
+	source := ConfigFile(cfg)
+	data, err := os.ReadFile(source)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Broker configuration file %s not found, using the default broker configuration\n", source)
+		return DefaultConfig(), "default", nil
+	}
+	if err != nil {
+		return nil, source, fmt.Errorf("failed to read broker configuration %s: %w", source, err)
+	}
+
+	brokerConfig, err := ParseConfig(data)
+	if err != nil {
+		return nil, source, fmt.Errorf("invalid broker configuration in %s: %w", source, err)
+	}
+
+	return brokerConfig, source, nil
+}
+
+// ConfigFile is the broker configuration file named by mqtt_broker.config_file, config.json by default.
+func ConfigFile(cfg *server_config.Config) string {
+	if cfg.MQTT.Config_File == "" {
+		return defaultConfigFile
+	}
+	return cfg.MQTT.Config_File
+}
+
+// ParseConfig decodes and validates a JSON broker configuration. Capabilities left out of the document keep
+// mochi's defaults.
+func ParseConfig(data []byte) (*Config, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if err := checkKeys("", raw, reflect.TypeOf(Config{})); err != nil {
+		return nil, err
+	}
+
+	brokerConfig := &Config{
+		Options: mqtt.Options{Capabilities: mqtt.NewDefaultServerCapabilities()},
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(brokerConfig); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return nil, err
+	}
+
+	if brokerConfig.Options.Capabilities == nil {
+		brokerConfig.Options.Capabilities = mqtt.NewDefaultServerCapabilities()
+	}
+
+	if err := brokerConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	return brokerConfig, nil
+}
+
+// checkKeys reports the first key in the document that does not map to a field of t, by its full path.
+func checkKeys(path string, value interface{}, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			for key, child := range v {
+				field, ok := fieldByJSONKey(t, key)
+				if !ok {
+					return fmt.Errorf("%s: unknown key", joinPath(path, key))
+				}
+				if err := checkKeys(joinPath(path, key), child, field.Type); err != nil {
+					return err
+				}
+			}
+		case reflect.Map:
+			for key, child := range v {
+				if err := checkKeys(joinPath(path, key), child, t.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, child := range v {
+				if err := checkKeys(fmt.Sprintf("%s[%d]", path, i), child, t.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldByJSONKey finds the struct field encoding/json would decode key into.
+func fieldByJSONKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Validate checks the values that decoding alone cannot. Every problem is reported, each prefixed by its key.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Options.Listeners) > 0 {
+		errs = append(errs, errors.New("options.listeners: not supported, use the top-level listeners key"))
+	}
+
+	if len(c.Listeners) == 0 {
+		errs = append(errs, errors.New("listeners: at least one listener is required"))
+	}
+
+	ids := make(map[string]bool)
+	addresses := make(map[string]string)
+	for i, l := range c.Listeners {
+		key := fmt.Sprintf("listeners[%d]", i)
+
+		if !isListenerType(l.Type) {
+			errs = append(errs, fmt.Errorf("%s.type: unknown listener type %q, expected one of %s", key, l.Type, strings.Join(listenerTypes, ", ")))
+		}
+
+		if l.ID == "" {
+			errs = append(errs, fmt.Errorf("%s.id: required", key))
+		} else if ids[l.ID] {
+			errs = append(errs, fmt.Errorf("%s.id: duplicate listener id %q", key, l.ID))
+		}
+		ids[l.ID] = true
+
+		if l.Address == "" {
+			errs = append(errs, fmt.Errorf("%s.address: required", key))
+		} else if other, ok := addresses[l.Address]; ok {
+			errs = append(errs, fmt.Errorf("%s.address: %s is already used by listener %q", key, l.Address, other))
+		} else {
+			addresses[l.Address] = l.ID
+		}
+
+		if l.TLS != nil {
+			if l.Type != listeners.TypeTCP && l.Type != listeners.TypeWS {
+				errs = append(errs, fmt.Errorf("%s.tls: only supported on %s and %s listeners", key, listeners.TypeTCP, listeners.TypeWS))
+			}
+			errs = append(errs, l.TLS.validate(key+".tls")...)
+		}
+	}
+
+	if c.Hooks.Storage != nil {
+		errs = append(errs, c.Hooks.Storage.validate("hooks.storage")...)
+	}
+
+	caps := c.Options.Capabilities
+	if caps.MaximumQos > 2 {
+		errs = append(errs, fmt.Errorf("options.capabilities.maximum_qos: must be 0, 1 or 2, got %d", caps.MaximumQos))
+	}
+	if caps.MinimumProtocolVersion != 0 && (caps.MinimumProtocolVersion < 3 || caps.MinimumProtocolVersion > 5) {
+		errs = append(errs, fmt.Errorf("options.capabilities.minimum_protocol_version: must be 3, 4 or 5, got %d", caps.MinimumProtocolVersion))
+	}
+	if caps.ReceiveMaximum == 0 {
+		errs = append(errs, errors.New("options.capabilities.receive_maximum: must be greater than 0"))
+	}
+	if caps.MaximumClients < 0 {
+		errs = append(errs, fmt.Errorf("options.capabilities.maximum_clients: must not be negative, got %d", caps.MaximumClients))
+	}
+
+	flags := map[string]byte{
+		"shared_sub_available":   caps.SharedSubAvailable,
+		"retain_available":       caps.RetainAvailable,
+		"wildcard_sub_available": caps.WildcardSubAvailable,
+		"sub_id_available":       caps.SubIDAvailable,
+	}
+	for _, name := range []string{"shared_sub_available", "retain_available", "wildcard_sub_available", "sub_id_available"} {
+		if flags[name] > 1 {
+			errs = append(errs, fmt.Errorf("options.capabilities.%s: must be 0 or 1, got %d", name, flags[name]))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isListenerType(listenerType string) bool {
+	for _, t := range listenerTypes {
+		if t == listenerType {
+			return true
+		}
+	}
+	return false
+}
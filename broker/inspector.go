@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Publish is a message that went through the broker, from a network client or the inline client.
+type Publish struct {
+	Topic     string
+	Payload   []byte
+	Client    string
+	QoS       byte
+	Retain    bool
+	Size      int
+	Timestamp time.Time
+}
+
+// Data returns JSON payloads as they are, anything else as text.
+func (p Publish) Data() interface{} {
+	if json.Valid(p.Payload) {
+		return json.RawMessage(p.Payload)
+	}
+	return string(p.Payload)
+}
+
+// InspectorHook hands the publishes of network clients to a sink, such as the WS hub. Those of the inline
+// client are left out, the simulator reporting its own publishes.
+type InspectorHook struct {
+	mqtt.HookBase
+	sink atomic.Pointer[func(Publish)]
+}
+
+func (h *InspectorHook) ID() string {
+	return "traffic-inspector"
+}
+
+func (h *InspectorHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublished,
+	}, []byte{b})
+}
+
+// SetSink sets the function receiving publishes. It is called on the broker's publish path.
+func (h *InspectorHook) SetSink(sink func(Publish)) {
+	h.sink.Store(&sink)
+}
+
+func (h *InspectorHook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	sink := h.sink.Load()
+	if sink == nil || cl.Net.Inline {
+		return
+	}
+
+	(*sink)(Publish{
+		Topic:     pk.TopicName,
+		Payload:   pk.Payload,
+		Client:    cl.ID,
+		QoS:       pk.FixedHeader.Qos,
+		Retain:    pk.FixedHeader.Retain,
+		Size:      len(pk.Payload),
+		Timestamp: time.Now(),
+	})
+}
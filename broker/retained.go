@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"mqtt-mochi-server/mqtttopic"
+)
+
+const previewSize = 256
+
+var ErrRetainedNotFound = errors.New("no retained message")
+
+// RetainedMessage describes a message retained by the broker, with the start of its payload.
+type RetainedMessage struct {
+	Topic     string     `json:"topic"`
+	Preview   string     `json:"preview"`
+	Truncated bool       `json:"truncated"`
+	Size      int        `json:"size"`
+	QoS       byte       `json:"qos"`
+	Client    string     `json:"client"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// preview returns the start of a payload, cut on a rune boundary so that it stays valid UTF-8.
+func preview(payload []byte) (string, bool) {
+	if len(payload) <= previewSize {
+		return string(payload), false
+	}
+	end := previewSize
+	for end > 0 && !utf8.RuneStart(payload[end]) {
+		end--
+	}
+	return string(payload[:end]), true
+}
+
+func newRetainedMessage(pk packets.Packet) RetainedMessage {
+	msg := RetainedMessage{
+		Topic:     pk.TopicName,
+		Size:      len(pk.Payload),
+		QoS:       pk.FixedHeader.Qos,
+		Client:    pk.Origin,
+		CreatedAt: time.Unix(pk.Created, 0),
+	}
+	msg.Preview, msg.Truncated = preview(pk.Payload)
+
+	if pk.Expiry > 0 {
+		t := time.Unix(pk.Expiry, 0)
+		msg.ExpiresAt = &t
+	}
+
+	return msg
+}
+
+// Retained lists the retained messages whose topic matches filter, sorted by topic. $SYS topics are only
+// listed by filters starting with $SYS.
+func (b *Broker) Retained(filter string) ([]RetainedMessage, error) {
+	if err := mqtttopic.ValidateFilter(filter); err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", filter, err)
+	}
+
+	messages := []RetainedMessage{}
+	for _, pk := range b.Server.Topics.Messages(filter) {
+		messages = append(messages, newRetainedMessage(pk))
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Topic < messages[j].Topic })
+	return messages, nil
+}
+
+// RetainedMessage returns the message retained on topic.
+func (b *Broker) RetainedMessage(topic string) (RetainedMessage, error) {
+	pk, ok := b.Server.Topics.Retained.Get(topic)
+	if !ok {
+		return RetainedMessage{}, fmt.Errorf("%w on %s", ErrRetainedNotFound, topic)
+	}
+	return newRetainedMessage(pk), nil
+}
+
+// ClearRetained removes the message retained on topic. It publishes an empty retained message, as a client
+// would, so storage hooks drop it too and current subscribers see it cleared.
+func (b *Broker) ClearRetained(topic string) error {
+	if _, ok := b.Server.Topics.Retained.Get(topic); !ok {
+		return fmt.Errorf("%w on %s", ErrRetainedNotFound, topic)
+	}
+	if err := b.Server.Publish(topic, nil, true, 0); err != nil {
+		return fmt.Errorf("failed to clear retained message on %s: %w", topic, err)
+	}
+	return nil
+}
+
+// ClearRetainedMatching removes the retained messages whose topic matches filter, and returns their topics.
+func (b *Broker) ClearRetainedMatching(filter string) ([]string, error) {
+	messages, err := b.Retained(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := []string{}
+	for _, msg := range messages {
+		if err := b.ClearRetained(msg.Topic); errors.Is(err, ErrRetainedNotFound) {
+			continue
+		} else if err != nil {
+			return topics, err
+		}
+		topics = append(topics, msg.Topic)
+	}
+
+	return topics, nil
+}
@@ -0,0 +1,297 @@
+package broker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/badger"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/bolt"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/pebble"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	StorageBolt   = "bolt"
+	StorageBadger = "badger"
+	StoragePebble = "pebble"
+)
+
+var storageTypes = []string{StorageBolt, StorageBadger, StoragePebble}
+
+// StorageConfig enables one of mochi's storage hooks, so that sessions, subscriptions, retained and inflight
+// messages survive a restart. Settings for every backend may be kept, only the one named by type is used.
+type StorageConfig struct {
+	Type    string              `json:"type"`
+	DataDir string              `json:"data_dir"`
+	Bolt    BoltStorageConfig   `json:"bolt"`
+	Badger  BadgerStorageConfig `json:"badger"`
+	Pebble  PebbleStorageConfig `json:"pebble"`
+}
+
+type BoltStorageConfig struct {
+	Path   string `json:"path"`
+	Bucket string `json:"bucket"`
+}
+
+type BadgerStorageConfig struct {
+	Path           string  `json:"path"`
+	GcInterval     int64   `json:"gc_interval"`
+	GcDiscardRatio float64 `json:"gc_discard_ratio"`
+}
+
+type PebbleStorageConfig struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+func (c *StorageConfig) validate(key string) []error {
+	var errs []error
+
+	if c.Type != "" && !isStorageType(c.Type) {
+		errs = append(errs, fmt.Errorf("%s.type: unknown storage %q, expected one of %s", key, c.Type, strings.Join(storageTypes, ", ")))
+	}
+	if c.Badger.GcInterval < 0 {
+		errs = append(errs, fmt.Errorf("%s.badger.gc_interval: must not be negative, got %d", key, c.Badger.GcInterval))
+	}
+	if c.Badger.GcDiscardRatio < 0 || c.Badger.GcDiscardRatio >= 1 {
+		errs = append(errs, fmt.Errorf("%s.badger.gc_discard_ratio: must be between 0 and 1, got %v", key, c.Badger.GcDiscardRatio))
+	}
+	if c.Pebble.Mode != "" && c.Pebble.Mode != pebble.Sync && c.Pebble.Mode != pebble.NoSync {
+		errs = append(errs, fmt.Errorf("%s.pebble.mode: unknown mode %q, expected %s or %s", key, c.Pebble.Mode, pebble.Sync, pebble.NoSync))
+	}
+
+	return errs
+}
+
+func isStorageType(storageType string) bool {
+	for _, t := range storageTypes {
+		if t == storageType {
+			return true
+		}
+	}
+	return false
+}
+
+// path resolves a storage path against the data directory. Absolute paths are kept as they are.
+func (c *StorageConfig) path(path, fallback string) string {
+	if path == "" {
+		path = fallback
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.DataDir, path)
+}
+
+// newHook returns the storage hook to add along with its options, or nil when storage is disabled.
+func (c *StorageConfig) newHook() (mqtt.Hook, any, error) {
+	if c.Type == "" {
+		return nil, nil, nil
+	}
+
+	if c.DataDir != "" {
+		if err := os.MkdirAll(c.DataDir, 0o755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+
+	switch c.Type {
+	case StorageBolt:
+		return new(bolt.Hook), &bolt.Options{Path: c.path(c.Bolt.Path, "bolt.db"), Bucket: c.Bolt.Bucket}, nil
+	case StorageBadger:
+		return new(badger.Hook), &badger.Options{
+			Path:           c.path(c.Badger.Path, "badger.db"),
+			GcInterval:     c.Badger.GcInterval,
+			GcDiscardRatio: c.Badger.GcDiscardRatio,
+		}, nil
+	default:
+		return new(pebble.Hook), &pebble.Options{Path: c.path(c.Pebble.Path, "pebble.db"), Mode: c.Pebble.Mode}, nil
+	}
+}
+
+// WipeReport counts what Wipe removed, in memory and in storage. Skipped counts the sessions of clients that
+// were still connected when the state was dropped, which are left as they are.
+type WipeReport struct {
+	Disconnected  int `json:"disconnected"`
+	Sessions      int `json:"sessions"`
+	Subscriptions int `json:"subscriptions"`
+	Retained      int `json:"retained"`
+	Inflight      int `json:"inflight"`
+	Skipped       int `json:"skipped"`
+}
+
+const wipeDisconnectTimeout = 5 * time.Second
+
+// Wipe drops every session, subscription, retained and inflight message, as if the broker started from an empty
+// store. Connected clients are disconnected first, so that they reconnect without a session to resume, and new
+// connections are refused until the wipe is done.
+func (b *Broker) Wipe() (WipeReport, error) {
+	var report WipeReport
+	server := b.Server
+
+	b.gate.close()
+	defer b.gate.open()
+
+	var disconnected []*mqtt.Client
+	for _, cl := range server.Clients.GetAll() {
+		if cl.Net.Inline || cl.Net.Conn == nil || cl.Closed() {
+			continue
+		}
+		_ = server.DisconnectClient(cl, packets.ErrAdministrativeAction)
+		disconnected = append(disconnected, cl)
+		report.Disconnected++
+	}
+
+	// Disconnected clients write their session to storage on the way out, so wait for them before wiping
+	deadline := time.Now().Add(wipeDisconnectTimeout)
+	for !b.gate.gone(disconnected) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Clients still connected keep their session, rather than being left connected without one
+	skipped := make(map[string]bool)
+	for _, cl := range server.Clients.GetAll() {
+		if cl.Net.Inline {
+			continue
+		}
+		if !cl.Closed() {
+			skipped[cl.ID] = true
+			report.Skipped++
+			continue
+		}
+		report.Subscriptions += cl.State.Subscriptions.Len()
+		report.Inflight += cl.State.Inflight.Len()
+		cl.ClearInflights()
+		server.UnsubscribeClient(cl)
+		server.Clients.Delete(cl.ID)
+		report.Sessions++
+	}
+
+	for topic := range server.Topics.Retained.GetAll() {
+		if strings.HasPrefix(topic, "$SYS") {
+			continue
+		}
+		server.Topics.RetainMessage(packets.Packet{TopicName: topic})
+		report.Retained++
+	}
+
+	if b.storage != nil {
+		if err := wipeStorage(b.storage, skipped); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// wipeGate refuses connections while the broker is wiped, and tells when disconnected clients are done. It is
+// added after the storage hook, so that its OnDisconnect runs once the session is stored.
+type wipeGate struct {
+	mqtt.HookBase
+
+	mu      sync.Mutex
+	closed  bool
+	stopped map[*mqtt.Client]bool
+}
+
+func (h *wipeGate) ID() string {
+	return "wipe-gate"
+}
+
+func (h *wipeGate) Provides(b byte) bool {
+	return b == mqtt.OnConnect || b == mqtt.OnDisconnect
+}
+
+func (h *wipeGate) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	h.stopped = make(map[*mqtt.Client]bool)
+}
+
+func (h *wipeGate) open() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = false
+	h.stopped = nil
+}
+
+// gone reports whether all the clients have disconnected since the gate was closed.
+func (h *wipeGate) gone(clients []*mqtt.Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range clients {
+		if !h.stopped[cl] {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *wipeGate) OnConnect(cl *mqtt.Client, pk packets.Packet) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		h.Log.Info("client refused while the broker is wiped", "client", cl.ID, "remote", cl.Net.Remote)
+		return packets.ErrServerBusy
+	}
+	return nil
+}
+
+func (h *wipeGate) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		h.stopped[cl] = true
+	}
+}
+
+// wipeStorage deletes the stored records through the hook's own event handlers, as the stores are not exposed.
+func wipeStorage(h mqtt.Hook, skipped map[string]bool) error {
+	clients, err := h.StoredClients()
+	if err != nil {
+		return fmt.Errorf("failed to read stored clients: %w", err)
+	}
+	for _, client := range clients {
+		if !skipped[client.ID] {
+			h.OnClientExpired(&mqtt.Client{ID: client.ID})
+		}
+	}
+
+	subscriptions, err := h.StoredSubscriptions()
+	if err != nil {
+		return fmt.Errorf("failed to read stored subscriptions: %w", err)
+	}
+	for _, sub := range subscriptions {
+		if skipped[sub.Client] {
+			continue
+		}
+		h.OnUnsubscribed(&mqtt.Client{ID: sub.Client}, packets.Packet{Filters: packets.Subscriptions{{Filter: sub.Filter}}})
+	}
+
+	retained, err := h.StoredRetainedMessages()
+	if err != nil {
+		return fmt.Errorf("failed to read stored retained messages: %w", err)
+	}
+	for _, msg := range retained {
+		h.OnRetainedExpired(msg.TopicName)
+	}
+
+	inflight, err := h.StoredInflightMessages()
+	if err != nil {
+		return fmt.Errorf("failed to read stored inflight messages: %w", err)
+	}
+	for _, msg := range inflight {
+		if skipped[msg.Client] {
+			continue
+		}
+		h.OnQosComplete(&mqtt.Client{ID: msg.Client}, packets.Packet{PacketID: msg.PacketID})
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Subscription receives the messages published on the embedded broker whose topic matches its filter,
+// through an inline client subscription. Messages are dropped when C is full.
+type Subscription struct {
+	C <-chan Publish
+
+	server  *mqtt.Server
+	filter  string
+	id      int
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+var subscriptionIDs atomic.Int64
+
+// Subscribe starts receiving the messages whose topic matches filter, with buffer messages at most waiting
+// to be read. Matching retained messages are received first unless retained is false.
+func (b *Broker) Subscribe(filter string, retained bool, buffer int) (*Subscription, error) {
+	c := make(chan Publish, buffer)
+	sub := &Subscription{C: c, server: b.Server, filter: filter, id: int(subscriptionIDs.Add(1))}
+
+	// mochi hands the retained messages over while subscribing, live ones keep their retain flag
+	var subscribed atomic.Bool
+	err := b.Server.Subscribe(filter, sub.id, func(cl *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		if !retained && !subscribed.Load() && pk.FixedHeader.Retain {
+			return
+		}
+
+		select {
+		case c <- Publish{
+			Topic:     pk.TopicName,
+			Payload:   pk.Payload,
+			Client:    pk.Origin,
+			QoS:       pk.FixedHeader.Qos,
+			Retain:    pk.FixedHeader.Retain,
+			Size:      len(pk.Payload),
+			Timestamp: time.Now(),
+		}:
+		default:
+			sub.dropped.Add(1)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	subscribed.Store(true)
+
+	return sub, nil
+}
+
+// Dropped counts the messages lost because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close ends the subscription. C is left open.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.server.Unsubscribe(s.filter, s.id)
+	})
+}
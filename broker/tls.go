@@ -0,0 +1,193 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+const (
+	UsernameFromCN  = "cn"
+	UsernameFromSAN = "san"
+)
+
+// TLSConfig serves a tcp or ws listener over TLS. With client_auth set, client certificates are verified
+// against ca_file, and username_from makes the certificate identity the username seen by the auth hook.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	CAFile       string `json:"ca_file"`
+	ClientAuth   string `json:"client_auth"`
+	UsernameFrom string `json:"username_from"`
+}
+
+func (c *TLSConfig) validate(key string) []error {
+	var errs []error
+
+	if c.CertFile == "" {
+		errs = append(errs, fmt.Errorf("%s.cert_file: required", key))
+	}
+	if c.KeyFile == "" {
+		errs = append(errs, fmt.Errorf("%s.key_file: required", key))
+	}
+
+	switch c.ClientAuth {
+	case "", ClientAuthNone:
+		if c.UsernameFrom != "" {
+			errs = append(errs, fmt.Errorf("%s.username_from: requires client_auth to be %s or %s", key, ClientAuthRequest, ClientAuthRequire))
+		}
+	case ClientAuthRequest, ClientAuthRequire:
+		if c.CAFile == "" {
+			errs = append(errs, fmt.Errorf("%s.ca_file: required to verify client certificates", key))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.client_auth: unknown mode %q, expected %s, %s or %s", key, c.ClientAuth, ClientAuthNone, ClientAuthRequest, ClientAuthRequire))
+	}
+
+	switch c.UsernameFrom {
+	case "", UsernameFromCN, UsernameFromSAN:
+	default:
+		errs = append(errs, fmt.Errorf("%s.username_from: unknown source %q, expected %s or %s", key, c.UsernameFrom, UsernameFromCN, UsernameFromSAN))
+	}
+
+	return errs
+}
+
+// load reads the certificate files into a tls.Config.
+func (c *TLSConfig) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		caBytes, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no PEM certificate found in %s", c.CAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	switch c.ClientAuth {
+	case ClientAuthRequest:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// CertIdentityHook replaces the CONNECT username of clients on mutual-TLS listeners by the identity of their
+// verified certificate, so that auth rules can match certificate identities.
+type CertIdentityHook struct {
+	mqtt.HookBase
+
+	// usernameFrom is the identity source, keyed on listener id
+	usernameFrom map[string]string
+}
+
+func (h *CertIdentityHook) ID() string {
+	return "tls-cert-identity"
+}
+
+func (h *CertIdentityHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnect,
+	}, []byte{b})
+}
+
+func (h *CertIdentityHook) Init(config any) error {
+	usernameFrom, ok := config.(map[string]string)
+	if !ok {
+		return mqtt.ErrInvalidConfigType
+	}
+
+	h.usernameFrom = usernameFrom
+	return nil
+}
+
+// OnConnect runs before authentication. Clients without a usable certificate are refused, rather than
+// falling back on the username they sent.
+func (h *CertIdentityHook) OnConnect(cl *mqtt.Client, pk packets.Packet) error {
+	source, ok := h.usernameFrom[cl.Net.Listener]
+	if !ok {
+		return nil
+	}
+
+	certs := peerCertificates(cl.Net.Conn)
+	if len(certs) == 0 {
+		h.Log.Info("client presented no certificate", "client", cl.ID, "listener", cl.Net.Listener, "remote", cl.Net.Remote)
+		return packets.ErrNotAuthorized
+	}
+
+	username := certUsername(certs[0], source)
+	if username == "" {
+		h.Log.Info("client certificate has no identity", "client", cl.ID, "listener", cl.Net.Listener, "source", source)
+		return packets.ErrNotAuthorized
+	}
+
+	cl.Properties.Username = []byte(username)
+	return nil
+}
+
+func certUsername(cert *x509.Certificate, source string) string {
+	if source == UsernameFromCN {
+		return cert.Subject.CommonName
+	}
+
+	switch {
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// peerCertificates returns the verified client certificates of a connection. Websocket connections are
+// wrapped by mochi, so the TLS connection is looked up in the embedded net.Conn.
+func peerCertificates(conn net.Conn) []*x509.Certificate {
+	for conn != nil {
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			return tlsConn.ConnectionState().PeerCertificates
+		}
+
+		v := reflect.ValueOf(conn)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		field := v.Elem().FieldByName("Conn")
+		if !field.IsValid() || !field.CanInterface() {
+			return nil
+		}
+		conn, _ = field.Interface().(net.Conn)
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package broker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// maxTrackedTopics bounds the memory used by TopicStatsHook; topics seen past it are not counted.
+const maxTrackedTopics = 100000
+
+var ErrTopicNotFound = errors.New("topic not found")
+
+type topicStats struct {
+	messages    uint64
+	lastPayload string
+	truncated   bool
+	lastSeen    time.Time
+}
+
+// TopicStatsHook counts the messages published on each topic and keeps the last one. Topics are kept in a
+// tree of their levels, so that a subtree can be read without going through every topic.
+type TopicStatsHook struct {
+	mqtt.HookBase
+	mu     sync.Mutex
+	root   statsNode
+	topics int
+}
+
+type statsNode struct {
+	stats    *topicStats
+	children map[string]*statsNode
+}
+
+func (h *TopicStatsHook) ID() string {
+	return "topic-stats"
+}
+
+func (h *TopicStatsHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublished,
+	}, []byte{b})
+}
+
+func (h *TopicStatsHook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	if strings.HasPrefix(pk.TopicName, "$SYS") {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.node(pk.TopicName, false)
+	if n == nil || n.stats == nil {
+		if h.topics >= maxTrackedTopics {
+			return
+		}
+		n = h.node(pk.TopicName, true)
+		n.stats = new(topicStats)
+		h.topics++
+	}
+
+	n.stats.messages++
+	n.stats.lastPayload, n.stats.truncated = preview(pk.Payload)
+	n.stats.lastSeen = time.Now()
+}
+
+// node returns the node of topic, the root when empty, creating it and its parents when create is set, or
+// nil when it does not exist.
+func (h *TopicStatsHook) node(topic string, create bool) *statsNode {
+	n := &h.root
+	if topic == "" {
+		return n
+	}
+	for _, level := range strings.Split(topic, "/") {
+		child, ok := n.children[level]
+		if !ok {
+			if !create {
+				return nil
+			}
+			if n.children == nil {
+				n.children = make(map[string]*statsNode)
+			}
+			child = new(statsNode)
+			n.children[level] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// subtree copies the stats of prefix and of the topics below it, or of every topic when prefix is empty.
+func (h *TopicStatsHook) subtree(prefix string) map[string]topicStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	topics := make(map[string]topicStats)
+	var walk func(n *statsNode, path string)
+	walk = func(n *statsNode, path string) {
+		if n.stats != nil {
+			topics[path] = *n.stats
+		}
+		for level, child := range n.children {
+			if n == &h.root {
+				walk(child, level)
+			} else {
+				walk(child, path+"/"+level)
+			}
+		}
+	}
+	if n := h.node(prefix, false); n != nil {
+		walk(n, prefix)
+	}
+	return topics
+}
+
+// TopicNode is a level of the topic hierarchy. Messages, LastPayload, LastSeen, Subscribers and Retained are
+// about the node's own topic; Topics, TotalMessages and LastActivity cover the node and everything below it.
+type TopicNode struct {
+	Name          string      `json:"name"`
+	Path          string      `json:"path"`
+	Configured    bool        `json:"configured"`
+	Messages      uint64      `json:"messages"`
+	LastPayload   *string     `json:"last_payload,omitempty"`
+	Truncated     bool        `json:"truncated,omitempty"`
+	LastSeen      *time.Time  `json:"last_seen,omitempty"`
+	Subscribers   int         `json:"subscribers"`
+	Retained      bool        `json:"retained"`
+	Topics        int         `json:"topics"`
+	TotalMessages uint64      `json:"total_messages"`
+	LastActivity  *time.Time  `json:"last_activity,omitempty"`
+	HasChildren   bool        `json:"has_children"`
+	Children      []TopicNode `json:"children,omitempty"`
+}
+
+type topicEntry struct {
+	configured bool
+	observed   bool
+	stats      topicStats
+}
+
+type treeNode struct {
+	name     string
+	path     string
+	entry    *topicEntry
+	children map[string]*treeNode
+}
+
+// TopicTree builds the hierarchy of the configured topics and of those seen on the broker, or holding a
+// retained message, and returns the node at prefix (the root when empty) expanded depth levels down, or
+// fully when depth is 0. $SYS topics are only included under a $SYS prefix.
+func (b *Broker) TopicTree(configured []string, prefix string, depth int) (TopicNode, error) {
+	entries := make(map[string]*topicEntry)
+	add := func(topic string) *topicEntry {
+		if e, ok := entries[topic]; ok {
+			return e
+		}
+		if !strings.HasPrefix(prefix, "$") && strings.HasPrefix(topic, "$") {
+			return nil
+		}
+		if prefix != "" && topic != prefix && !strings.HasPrefix(topic, prefix+"/") {
+			return nil
+		}
+		e := new(topicEntry)
+		entries[topic] = e
+		return e
+	}
+
+	for _, topic := range configured {
+		if e := add(topic); e != nil {
+			e.configured = true
+		}
+	}
+	for topic, stats := range b.TopicStats.subtree(prefix) {
+		if e := add(topic); e != nil {
+			e.observed = true
+			e.stats = stats
+		}
+	}
+	for _, topic := range b.retainedTopics(prefix) {
+		add(topic)
+	}
+
+	if prefix != "" && len(entries) == 0 {
+		return TopicNode{}, fmt.Errorf("%w: %s", ErrTopicNotFound, prefix)
+	}
+
+	root := &treeNode{path: prefix, children: make(map[string]*treeNode)}
+	if prefix != "" {
+		root.name = prefix[strings.LastIndex(prefix, "/")+1:]
+	}
+	for topic, e := range entries {
+		n := root
+		if topic != prefix {
+			rest := topic
+			if prefix != "" {
+				rest = topic[len(prefix)+1:]
+			}
+			for _, level := range strings.Split(rest, "/") {
+				child, ok := n.children[level]
+				if !ok {
+					path := level
+					if n.path != "" || n != root {
+						path = n.path + "/" + level
+					}
+					child = &treeNode{name: level, path: path, children: make(map[string]*treeNode)}
+					n.children[level] = child
+				}
+				n = child
+			}
+		}
+		n.entry = e
+	}
+
+	levels := depth
+	if depth == 0 {
+		levels = -1
+	}
+	return b.topicNode(root, levels), nil
+}
+
+// retainedTopics lists the topics holding a retained message at prefix and below, or everywhere but $SYS when
+// prefix is empty, through the topic index rather than every retained message.
+func (b *Broker) retainedTopics(prefix string) []string {
+	topics := b.Server.Topics
+	var pks []packets.Packet
+	switch {
+	case prefix == "":
+		pks = topics.Messages("#")
+	case strings.ContainsAny(prefix, "+#"):
+		// No topic holds a wildcard
+	default:
+		if pk, ok := topics.Retained.Get(prefix); ok {
+			pks = append(pks, pk)
+		}
+		pks = append(pks, topics.Messages(prefix+"/#")...)
+	}
+
+	names := make([]string, 0, len(pks))
+	for _, pk := range pks {
+		names = append(names, pk.TopicName)
+	}
+	return names
+}
+
+// topicNode converts n with its children levels down, or all of them when levels is negative. The
+// subscribers are only looked up for the nodes returned.
+func (b *Broker) topicNode(n *treeNode, levels int) TopicNode {
+	node := TopicNode{Name: n.name, Path: n.path, HasChildren: len(n.children) > 0}
+
+	if n.entry != nil {
+		node.Topics = 1
+		node.Configured = n.entry.configured
+		if n.entry.observed {
+			stats := n.entry.stats
+			node.Messages = stats.messages
+			node.LastPayload = &stats.lastPayload
+			node.Truncated = stats.truncated
+			node.LastSeen = &stats.lastSeen
+			node.TotalMessages = stats.messages
+			node.LastActivity = node.LastSeen
+		}
+	}
+	_, node.Retained = b.Server.Topics.Retained.Get(n.path)
+
+	subs := b.Server.Topics.Subscribers(n.path)
+	node.Subscribers = len(subs.Subscriptions) + len(subs.Shared) + len(subs.InlineSubscriptions)
+
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := n.children[name]
+		if levels == 0 {
+			topics, messages, last := child.totals()
+			node.Topics += topics
+			node.TotalMessages += messages
+			node.LastActivity = latest(node.LastActivity, last)
+			continue
+		}
+
+		c := b.topicNode(child, levels-1)
+		node.Topics += c.Topics
+		node.TotalMessages += c.TotalMessages
+		node.LastActivity = latest(node.LastActivity, c.LastActivity)
+		node.Children = append(node.Children, c)
+	}
+
+	return node
+}
+
+// totals aggregates the subtree of a node that is not expanded.
+func (n *treeNode) totals() (int, uint64, *time.Time) {
+	var topics int
+	var messages uint64
+	var last *time.Time
+	if n.entry != nil {
+		topics = 1
+		if n.entry.observed {
+			messages = n.entry.stats.messages
+			last = &n.entry.stats.lastSeen
+		}
+	}
+	for _, child := range n.children {
+		t, m, l := child.totals()
+		topics += t
+		messages += m
+		last = latest(last, l)
+	}
+	return topics, messages, last
+}
+
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
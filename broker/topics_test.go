@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+func newTopicsBroker(t *testing.T) *Broker {
+	t.Helper()
+	b := &Broker{Server: mqtt.New(&mqtt.Options{InlineClient: true}), TopicStats: new(TopicStatsHook)}
+
+	for topic, count := range map[string]int{
+		"site/a/temp":        3,
+		"site/a/hum":         1,
+		"site/b/temp":        2,
+		"/lead/x":            1,
+		"$app/status":        1,
+		"$SYS/broker/uptime": 1,
+	} {
+		for i := 1; i <= count; i++ {
+			b.TopicStats.OnPublished(nil, packets.Packet{TopicName: topic, Payload: []byte(fmt.Sprint(i))})
+		}
+	}
+	b.Server.Topics.RetainMessage(packets.Packet{TopicName: "site/r", Payload: []byte("on"), FixedHeader: packets.FixedHeader{Retain: true}})
+
+	return b
+}
+
+func children(n TopicNode) []string {
+	var names []string
+	for _, child := range n.Children {
+		names = append(names, child.Name)
+	}
+	return names
+}
+
+func child(t *testing.T, n TopicNode, name string) TopicNode {
+	t.Helper()
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("%q has no child %q, got %v", n.Path, name, children(n))
+	return TopicNode{}
+}
+
+func TestTopicTree(t *testing.T) {
+	b := newTopicsBroker(t)
+	configured := []string{"site/c/temp"}
+
+	for _, c := range []struct {
+		prefix        string
+		depth         int
+		name          string
+		topics        int
+		totalMessages uint64
+		messages      uint64
+		retained      bool
+		children      string
+	}{
+		// '$' topics are left out of the root, and "/lead/x" starts with an empty level
+		{prefix: "", depth: 0, topics: 6, totalMessages: 7, children: "[ site]"},
+		{prefix: "", depth: 1, topics: 6, totalMessages: 7, children: "[ site]"},
+		{prefix: "site", depth: 1, name: "site", topics: 5, totalMessages: 6, children: "[a b c r]"},
+		{prefix: "site/a", depth: 0, name: "a", topics: 2, totalMessages: 4, children: "[hum temp]"},
+		{prefix: "site/a/temp", depth: 0, name: "temp", topics: 1, totalMessages: 3, messages: 3, children: "[]"},
+		{prefix: "site/r", depth: 0, name: "r", topics: 1, retained: true, children: "[]"},
+		{prefix: "site/c", depth: 0, name: "c", topics: 1, children: "[temp]"},
+		{prefix: "/lead", depth: 0, name: "lead", topics: 1, totalMessages: 1, children: "[x]"},
+		{prefix: "$app", depth: 0, name: "$app", topics: 1, totalMessages: 1, children: "[status]"},
+	} {
+		n, err := b.TopicTree(configured, c.prefix, c.depth)
+		if err != nil {
+			t.Errorf("TopicTree(%q, %d): %v", c.prefix, c.depth, err)
+			continue
+		}
+		if n.Name != c.name || n.Path != c.prefix || n.Topics != c.topics || n.TotalMessages != c.totalMessages ||
+			n.Messages != c.messages || n.Retained != c.retained || fmt.Sprint(children(n)) != c.children {
+			t.Errorf("TopicTree(%q, %d) = %s %s: %d topics, %d/%d messages, retained %v, children %v", c.prefix, c.depth,
+				n.Name, n.Path, n.Topics, n.Messages, n.TotalMessages, n.Retained, children(n))
+		}
+	}
+}
+
+// Levels past depth are rolled up into their parent without being returned
+func TestTopicTreeDepth(t *testing.T) {
+	b := newTopicsBroker(t)
+
+	root, err := b.TopicTree(nil, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := child(t, child(t, root, "site"), "a")
+	if a.Path != "site/a" || a.Topics != 2 || a.TotalMessages != 4 || !a.HasChildren || a.Children != nil || a.LastActivity == nil {
+		t.Errorf("site/a = %+v, want 2 topics and 4 messages, not expanded", a)
+	}
+
+	lead := child(t, child(t, root, ""), "lead")
+	if lead.Path != "/lead" || lead.Topics != 1 {
+		t.Errorf("/lead = %+v, want 1 topic", lead)
+	}
+
+	full, err := b.TopicTree(nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := child(t, child(t, child(t, full, "site"), "a"), "temp")
+	if temp.Path != "site/a/temp" || temp.LastPayload == nil || *temp.LastPayload != "3" || temp.HasChildren {
+		t.Errorf("site/a/temp = %+v, want last payload 3", temp)
+	}
+}
+
+func TestTopicTreeNotFound(t *testing.T) {
+	b := newTopicsBroker(t)
+	for _, prefix := range []string{"missing", "site/a/temp/x", "site/+", "site/#", "$SYS/broker/uptime"} {
+		if _, err := b.TopicTree(nil, prefix, 0); !errors.Is(err, ErrTopicNotFound) {
+			t.Errorf("TopicTree(%q) = %v, want ErrTopicNotFound", prefix, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	server_config "mqtt-mochi-server/config"
+	"mqtt-mochi-server/db"
+)
+
+// runCommand handles the CLI subcommands. It reports false when args do not name a subcommand,
+// in which case the simulator starts as usual.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "migrate":
+		if err := migrateCommand(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			os.Exit(1)
+		}
+	case "export":
+		if err := exportCommand(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			os.Exit(1)
+		}
+	case "import":
+		if err := importCommand(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "import: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+func migrateCommand(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	dbConn, err := db.OpenDB(server_config.Main.Server_DB)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	switch action {
+	case "up":
+		return db.MigrateUp(dbConn)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		return db.MigrateDown(dbConn, steps)
+	case "status":
+		status, err := db.GetMigrationStatus(dbConn)
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown action %q, expected up, down [steps] or status", action)
+	}
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "write the bundle to this file instead of stdout")
+	format := flags.String("format", "", "bundle format, json or yaml (default: from the output file extension, else json)")
+	idsParam := flags.String("ids", "", "comma-separated message IDs to export (default: all)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *format == "" {
+		*format = db.BundleFormatJSON
+		if ext := strings.TrimPrefix(filepath.Ext(*output), "."); ext == "yaml" || ext == "yml" {
+			*format = db.BundleFormatYAML
+		}
+	}
+
+	var ids []int
+	if *idsParam != "" {
+		for _, idStr := range strings.Split(*idsParam, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				return fmt.Errorf("invalid id %q", idStr)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	dbConn, err := db.InitDB(server_config.Main.Server_DB)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	bundle, err := db.Export(dbConn, ids)
+	if err != nil {
+		return err
+	}
+
+	data, err := db.EncodeBundle(bundle, *format)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	return os.WriteFile(*output, data, 0644)
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	mode := flags.String("mode", db.ImportModeMerge, "merge into the existing messages, or replace them")
+	onConflict := flags.String("on-conflict", db.ConflictSkip, "when merging a message whose topic already exists: skip, overwrite or duplicate")
+	dryRun := flags.Bool("dry-run", false, "report what would change without changing anything")
+	format := flags.String("format", "", "bundle format, json or yaml (default: detected)")
+	author := flags.String("author", "cli", "author recorded in the message revisions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one bundle file, got %d", flags.NArg())
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	bundle, err := db.DecodeBundle(data, *format)
+	if err != nil {
+		return err
+	}
+
+	dbConn, err := db.InitDB(server_config.Main.Server_DB)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	report, err := db.Import(dbConn, bundle, db.ImportOptions{Mode: *mode, OnConflict: *onConflict, DryRun: *dryRun, Author: *author})
+	if err != nil {
+		return err
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(reportBytes))
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the environment variables overriding settings, e.g. MQTTSIM_SERVER_DB_PASSWORD for
+// server_db.password. A variable suffixed with _FILE names a file to read the value from instead.
+const EnvPrefix = "MQTTSIM_"
+
+// Overrides holds settings given on the command line, keyed like the config file, e.g. "general.http_port".
+// They take precedence over environment variables, which take precedence over the config file.
+var Overrides = map[string]string{}
+
+// EnvName is the environment variable overriding key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func (config *Config) applyOverrides() error {
+	sections := []struct {
+		key   string
+		value interface{}
+	}{
+		{"general", &config.General},
+		{"mqtt_broker", &config.MQTT},
+		{"server_db", &config.Server_DB},
+	}
+
+	for _, section := range sections {
+		if err := overrideFields(section.key, reflect.ValueOf(section.value).Elem()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func overrideFields(prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + "." + fieldKey(field)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := overrideFields(key, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, source, err := lookupOverride(key)
+		if err != nil {
+			return err
+		}
+		if source == "" {
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			return fmt.Errorf("invalid %s from %s: %w", key, source, err)
+		}
+	}
+
+	return nil
+}
+
+// fieldKey is the name of a field in the config file.
+func fieldKey(field reflect.StructField) string {
+	for _, tag := range []string{"mapstructure", "json"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return strings.ToLower(field.Name)
+}
+
+// lookupOverride returns the value overriding key and where it comes from, or an empty source when the
+// setting is not overridden.
+func lookupOverride(key string) (string, string, error) {
+	if value, ok := Overrides[key]; ok {
+		return value, "the command line", nil
+	}
+
+	env := EnvName(key)
+	if value, ok := os.LookupEnv(env); ok {
+		return value, env, nil
+	}
+
+	if path, ok := os.LookupEnv(env + "_FILE"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s_FILE: %w", env, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), env + "_FILE", nil
+	}
+
+	return "", "", nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
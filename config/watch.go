@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch calls onChange with the path of any watched file that is written: the main config file through viper,
+// the data file and the extra files, such as the broker configuration. It runs until the process exits.
+func (config *Config) Watch(onChange func(file string), extra ...string) error {
+	config.Main_config.OnConfigChange(func(e fsnotify.Event) {
+		onChange(e.Name)
+	})
+	config.Main_config.WatchConfig()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	// Directories are watched rather than files, so that editors replacing the file on save are noticed
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, file := range append([]string{config.DataFile()}, extra...) {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", file, err)
+		}
+		files[path] = true
+
+		dir := filepath.Dir(path)
+		if !dirs[dir] {
+			if err := watcher.Add(dir); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", dir, err)
+			}
+			dirs[dir] = true
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if files[filepath.Clean(event.Name)] && (event.Has(fsnotify.Write) || event.Has(fsnotify.Create)) {
+					onChange(event.Name)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("Error while watching configuration files: %v\n", err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,330 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrBrokerUserNotFound    = errors.New("broker user not found")
+	ErrBrokerACLRuleNotFound = errors.New("broker ACL rule not found")
+)
+
+// BrokerUser is an auth rule of the broker ledger. Client, Username and Remote are patterns where '*' matches
+// anything after it, and an empty value matches everything. A user without a password accepts any password.
+type BrokerUser struct {
+	ID           int       `json:"id"`
+	Position     int       `json:"position"`
+	Client       string    `json:"client"`
+	Username     string    `json:"username"`
+	Remote       string    `json:"remote"`
+	HasPassword  bool      `json:"has_password"`
+	PasswordHash string    `json:"-"`
+	Allow        bool      `json:"allow"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// BrokerACLRule grants access to topic filters, keyed on filter: 0 deny, 1 read, 2 write, 3 read and write.
+type BrokerACLRule struct {
+	ID        int            `json:"id"`
+	Position  int            `json:"position"`
+	Client    string         `json:"client"`
+	Username  string         `json:"username"`
+	Remote    string         `json:"remote"`
+	Filters   map[string]int `json:"filters"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// HashPassword hashes a broker password for storage. An empty password stays empty, meaning any password.
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the user's stored hash.
+func (u BrokerUser) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBrokerUser(row rowScanner) (BrokerUser, error) {
+	var user BrokerUser
+	var passwordHash sql.NullString
+	err := row.Scan(&user.ID, &user.Position, &user.Client, &user.Username, &user.Remote, &passwordHash, &user.Allow, &user.CreatedAt, &user.UpdatedAt)
+	user.PasswordHash = passwordHash.String
+	user.HasPassword = user.PasswordHash != ""
+	return user, err
+}
+
+func scanBrokerACLRule(row rowScanner) (BrokerACLRule, error) {
+	var rule BrokerACLRule
+	var filtersBytes []byte
+	if err := row.Scan(&rule.ID, &rule.Position, &rule.Client, &rule.Username, &rule.Remote, &filtersBytes, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+		return rule, err
+	}
+
+	if err := json.Unmarshal(filtersBytes, &rule.Filters); err != nil {
+		return rule, fmt.Errorf("failed to unmarshal filters: %w", err)
+	}
+
+	return rule, nil
+}
+
+const brokerUserColumns = "id, position, client, username, remote, password_hash, allow, created_at, updated_at"
+
+const brokerACLColumns = "id, position, client, username, remote, filters, created_at, updated_at"
+
+// ListBrokerUsers returns the auth rules in the order they are evaluated.
+func ListBrokerUsers(db *sql.DB) ([]BrokerUser, error) {
+	rows, err := db.Query("SELECT " + brokerUserColumns + " FROM broker_users ORDER BY position, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query broker users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []BrokerUser{}
+	for rows.Next() {
+		user, err := scanBrokerUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return users, nil
+}
+
+func GetBrokerUser(db *sql.DB, id int) (BrokerUser, error) {
+	user, err := scanBrokerUser(db.QueryRow("SELECT "+brokerUserColumns+" FROM broker_users WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrBrokerUserNotFound
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to retrieve broker user: %w", err)
+	}
+	return user, nil
+}
+
+// CreateBrokerUser stores a new auth rule, hashing its password.
+func CreateBrokerUser(db *sql.DB, user BrokerUser, password string) (BrokerUser, error) {
+	return createBrokerUser(db, user, password)
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func createBrokerUser(q rowQuerier, user BrokerUser, password string) (BrokerUser, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return user, err
+	}
+
+	created, err := scanBrokerUser(q.QueryRow("INSERT INTO broker_users (position, client, username, remote, password_hash, allow) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6) RETURNING "+brokerUserColumns,
+		user.Position, user.Client, user.Username, user.Remote, hash, user.Allow))
+	if err != nil {
+		return user, fmt.Errorf("failed to insert broker user: %w", err)
+	}
+
+	return created, nil
+}
+
+// UpdateBrokerUser overwrites an auth rule. A nil password keeps the current one.
+func UpdateBrokerUser(db *sql.DB, id int, user BrokerUser, password *string) (BrokerUser, error) {
+	query := "UPDATE broker_users SET position = $1, client = $2, username = $3, remote = $4, allow = $5, updated_at = NOW() WHERE id = $6 RETURNING " + brokerUserColumns
+	args := []interface{}{user.Position, user.Client, user.Username, user.Remote, user.Allow, id}
+
+	if password != nil {
+		hash, err := HashPassword(*password)
+		if err != nil {
+			return user, err
+		}
+		query = "UPDATE broker_users SET position = $1, client = $2, username = $3, remote = $4, allow = $5, password_hash = NULLIF($7, ''), updated_at = NOW() WHERE id = $6 RETURNING " + brokerUserColumns
+		args = append(args, hash)
+	}
+
+	updated, err := scanBrokerUser(db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrBrokerUserNotFound
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to update broker user: %w", err)
+	}
+
+	return updated, nil
+}
+
+func DeleteBrokerUser(db *sql.DB, id int) error {
+	result, err := db.Exec("DELETE FROM broker_users WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete broker user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrBrokerUserNotFound
+	}
+
+	return nil
+}
+
+// ListBrokerACL returns the ACL rules in the order they are evaluated.
+func ListBrokerACL(db *sql.DB) ([]BrokerACLRule, error) {
+	rows, err := db.Query("SELECT " + brokerACLColumns + " FROM broker_acl ORDER BY position, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query broker ACL: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []BrokerACLRule{}
+	for rows.Next() {
+		rule, err := scanBrokerACLRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return rules, nil
+}
+
+func CreateBrokerACLRule(db *sql.DB, rule BrokerACLRule) (BrokerACLRule, error) {
+	return createBrokerACLRule(db, rule)
+}
+
+func createBrokerACLRule(q rowQuerier, rule BrokerACLRule) (BrokerACLRule, error) {
+	filtersBytes, err := json.Marshal(rule.Filters)
+	if err != nil {
+		return rule, fmt.Errorf("failed to marshal filters: %w", err)
+	}
+
+	created, err := scanBrokerACLRule(q.QueryRow("INSERT INTO broker_acl (position, client, username, remote, filters) VALUES ($1, $2, $3, $4, $5) RETURNING "+brokerACLColumns,
+		rule.Position, rule.Client, rule.Username, rule.Remote, filtersBytes))
+	if err != nil {
+		return rule, fmt.Errorf("failed to insert broker ACL rule: %w", err)
+	}
+
+	return created, nil
+}
+
+func UpdateBrokerACLRule(db *sql.DB, id int, rule BrokerACLRule) (BrokerACLRule, error) {
+	filtersBytes, err := json.Marshal(rule.Filters)
+	if err != nil {
+		return rule, fmt.Errorf("failed to marshal filters: %w", err)
+	}
+
+	updated, err := scanBrokerACLRule(db.QueryRow("UPDATE broker_acl SET position = $1, client = $2, username = $3, remote = $4, filters = $5, updated_at = NOW() WHERE id = $6 RETURNING "+brokerACLColumns,
+		rule.Position, rule.Client, rule.Username, rule.Remote, filtersBytes, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return rule, ErrBrokerACLRuleNotFound
+	}
+	if err != nil {
+		return rule, fmt.Errorf("failed to update broker ACL rule: %w", err)
+	}
+
+	return updated, nil
+}
+
+func DeleteBrokerACLRule(db *sql.DB, id int) error {
+	result, err := db.Exec("DELETE FROM broker_acl WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete broker ACL rule: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrBrokerACLRuleNotFound
+	}
+
+	return nil
+}
+
+// BrokerLedgerUser is an auth rule to import, with its password in clear.
+type BrokerLedgerUser struct {
+	BrokerUser
+	Password string
+}
+
+// ImportBrokerLedger stores a whole ledger in one transaction. Imported rules are evaluated after the existing
+// ones, unless replace is set, in which case the existing rules are dropped first.
+func ImportBrokerLedger(db *sql.DB, users []BrokerLedgerUser, acl []BrokerACLRule, replace bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if replace {
+		if _, err := tx.Exec("DELETE FROM broker_users"); err != nil {
+			return fmt.Errorf("failed to clear broker users: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM broker_acl"); err != nil {
+			return fmt.Errorf("failed to clear broker ACL: %w", err)
+		}
+	}
+
+	var userOffset, aclOffset int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(position) + 1, 0) FROM broker_users").Scan(&userOffset); err != nil {
+		return fmt.Errorf("failed to query broker users: %w", err)
+	}
+	if err := tx.QueryRow("SELECT COALESCE(MAX(position) + 1, 0) FROM broker_acl").Scan(&aclOffset); err != nil {
+		return fmt.Errorf("failed to query broker ACL: %w", err)
+	}
+
+	for i, user := range users {
+		user.Position = userOffset + i
+		if _, err := createBrokerUser(tx, user.BrokerUser, user.Password); err != nil {
+			return err
+		}
+	}
+
+	for i, rule := range acl {
+		rule.Position = aclOffset + i
+		if _, err := createBrokerACLRule(tx, rule); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// BrokerLedgerEmpty reports whether no auth or ACL rule has been stored yet.
+func BrokerLedgerEmpty(db *sql.DB) (bool, error) {
+	var empty bool
+	err := db.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM broker_users) AND NOT EXISTS (SELECT 1 FROM broker_acl)").Scan(&empty)
+	if err != nil {
+		return false, fmt.Errorf("failed to query broker ledger: %w", err)
+	}
+	return empty, nil
+}
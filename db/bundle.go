@@ -0,0 +1,322 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BundleVersion is the version of the simulation bundle format written by Export.
+const BundleVersion = 1
+
+const (
+	BundleFormatJSON = "json"
+	BundleFormatYAML = "yaml"
+)
+
+const (
+	ImportModeMerge   = "merge"
+	ImportModeReplace = "replace"
+
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictDuplicate = "duplicate"
+)
+
+var (
+	ErrUnsupportedBundle    = errors.New("unsupported bundle version")
+	ErrInvalidImportOptions = errors.New("invalid import options")
+)
+
+// Bundle is a portable copy of a simulation, shared between team members and environments.
+type Bundle struct {
+	Version    int             `json:"version" yaml:"version"`
+	ExportedAt time.Time       `json:"exported_at" yaml:"exported_at"`
+	Messages   []BundleMessage `json:"messages" yaml:"messages"`
+}
+
+type BundleMessage struct {
+	ID        int         `json:"id" yaml:"id"`
+	Topic     string      `json:"topic" yaml:"topic"`
+	Payload   interface{} `json:"payload" yaml:"payload"`
+	Frequency int         `json:"frequency" yaml:"frequency"`
+}
+
+type ImportOptions struct {
+	Mode       string
+	OnConflict string
+	DryRun     bool
+	Author     string
+}
+
+type ImportConflict struct {
+	SourceID   int    `json:"source_id"`
+	Topic      string `json:"topic"`
+	ExistingID int    `json:"existing_id"`
+	Resolution string `json:"resolution"`
+}
+
+type ImportReport struct {
+	Mode      string           `json:"mode"`
+	DryRun    bool             `json:"dry_run"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Skipped   int              `json:"skipped"`
+	Deleted   int              `json:"deleted"`
+	IDMap     map[int]int      `json:"id_map"`
+	Conflicts []ImportConflict `json:"conflicts"`
+}
+
+// EncodeBundle writes the bundle as JSON or YAML.
+func EncodeBundle(bundle Bundle, format string) ([]byte, error) {
+	switch format {
+	case BundleFormatJSON, "":
+		return json.MarshalIndent(bundle, "", "  ")
+	case BundleFormatYAML, "yml":
+		return yaml.Marshal(bundle)
+	default:
+		return nil, fmt.Errorf("unknown bundle format %q", format)
+	}
+}
+
+// DecodeBundle reads a JSON or YAML bundle. With an empty format, a document starting with '{' is read as JSON
+// and anything else as YAML.
+func DecodeBundle(data []byte, format string) (Bundle, error) {
+	var bundle Bundle
+
+	if format == "" {
+		format = BundleFormatYAML
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			format = BundleFormatJSON
+		}
+	}
+
+	var err error
+	switch format {
+	case BundleFormatJSON:
+		err = json.Unmarshal(data, &bundle)
+	case BundleFormatYAML, "yml":
+		err = yaml.Unmarshal(data, &bundle)
+	default:
+		return bundle, fmt.Errorf("unknown bundle format %q", format)
+	}
+	if err != nil {
+		return bundle, fmt.Errorf("failed to decode bundle: %w", err)
+	}
+
+	return bundle, nil
+}
+
+// Export bundles the live messages. When ids is empty every message is exported.
+func Export(db *sql.DB, ids []int) (Bundle, error) {
+	bundle := Bundle{Version: BundleVersion, ExportedAt: time.Now().UTC(), Messages: []BundleMessage{}}
+
+	messages, err := FetchMessages(db)
+	if err != nil {
+		return bundle, err
+	}
+
+	selected := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	for _, msg := range messages {
+		if len(ids) > 0 && !selected[msg.ID] {
+			continue
+		}
+		bundle.Messages = append(bundle.Messages, BundleMessage{ID: msg.ID, Topic: msg.Topic, Payload: msg.Payload, Frequency: msg.Frequency})
+	}
+
+	return bundle, nil
+}
+
+// Import applies a bundle in a single transaction. A dry run performs the same work and rolls it back,
+// so the report shows exactly what would happen.
+func Import(db *sql.DB, bundle Bundle, opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{Mode: opts.Mode, DryRun: opts.DryRun, IDMap: map[int]int{}, Conflicts: []ImportConflict{}}
+
+	if bundle.Version != BundleVersion {
+		return report, fmt.Errorf("%w: %d, expected %d", ErrUnsupportedBundle, bundle.Version, BundleVersion)
+	}
+
+	if opts.Mode != ImportModeMerge && opts.Mode != ImportModeReplace {
+		return report, fmt.Errorf("%w: unknown mode %q, expected merge or replace", ErrInvalidImportOptions, opts.Mode)
+	}
+
+	if opts.OnConflict != ConflictSkip && opts.OnConflict != ConflictOverwrite && opts.OnConflict != ConflictDuplicate {
+		return report, fmt.Errorf("%w: unknown conflict resolution %q, expected skip, overwrite or duplicate", ErrInvalidImportOptions, opts.OnConflict)
+	}
+
+	// Payloads are compared as JSON values, whatever format the bundle was decoded from
+	for i := range bundle.Messages {
+		normalized, err := normalizePayload(bundle.Messages[i].Payload)
+		if err != nil {
+			return report, fmt.Errorf("invalid payload for message %d: %w", bundle.Messages[i].ID, err)
+		}
+		bundle.Messages[i].Payload = normalized
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return report, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := lockLiveMessages(tx)
+	if err != nil {
+		return report, err
+	}
+
+	if opts.Mode == ImportModeReplace {
+		for _, msg := range existing {
+			if err := deleteMessageTx(tx, msg.ID, opts.Author); err != nil {
+				return report, err
+			}
+			report.Deleted++
+		}
+		existing = nil
+	}
+
+	matches := matchExisting(existing, bundle.Messages)
+
+	for i, msg := range bundle.Messages {
+		snapshot := MessageSnapshot{Topic: msg.Topic, Payload: msg.Payload, Frequency: msg.Frequency}
+
+		current := matches[i]
+		if current == nil {
+			id, err := createMessageTx(tx, snapshot, opts.Author)
+			if err != nil {
+				return report, err
+			}
+			report.Created++
+			report.IDMap[msg.ID] = id
+			continue
+		}
+
+		if sameMessage(*current, msg) {
+			report.Skipped++
+			report.IDMap[msg.ID] = current.ID
+			continue
+		}
+
+		report.Conflicts = append(report.Conflicts, ImportConflict{SourceID: msg.ID, Topic: msg.Topic, ExistingID: current.ID, Resolution: opts.OnConflict})
+
+		switch opts.OnConflict {
+		case ConflictSkip:
+			report.Skipped++
+			report.IDMap[msg.ID] = current.ID
+		case ConflictOverwrite:
+			if err := updateMessageTx(tx, current.ID, snapshot, opts.Author); err != nil {
+				return report, err
+			}
+			report.Updated++
+			report.IDMap[msg.ID] = current.ID
+		case ConflictDuplicate:
+			id, err := createMessageTx(tx, snapshot, opts.Author)
+			if err != nil {
+				return report, err
+			}
+			report.Created++
+			report.IDMap[msg.ID] = id
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return report, nil
+}
+
+func lockLiveMessages(tx *sql.Tx) ([]Message, error) {
+	rows, err := tx.Query("SELECT id, topic, payload, frequency FROM messages WHERE deleted_at IS NULL ORDER BY id FOR UPDATE")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		var payloadBytes []byte
+		if err := rows.Scan(&msg.ID, &msg.Topic, &payloadBytes, &msg.Frequency); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if err := json.Unmarshal(payloadBytes, &msg.Payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+		}
+
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return messages, nil
+}
+
+// matchExisting pairs each bundle entry with an existing message on its topic, or nil. Topics are not unique,
+// so each message is matched by one entry at most, and identical messages are paired first. Messages created
+// by the import are not candidates, or a bundle holding two messages on a topic would conflict with itself.
+func matchExisting(existing []Message, entries []BundleMessage) []*Message {
+	byTopic := make(map[string][]Message, len(existing))
+	for _, msg := range existing {
+		byTopic[msg.Topic] = append(byTopic[msg.Topic], msg)
+	}
+
+	matches := make([]*Message, len(entries))
+	take := func(i, candidate int) {
+		candidates := byTopic[entries[i].Topic]
+		matches[i] = &candidates[candidate]
+		byTopic[entries[i].Topic] = slices.Delete(slices.Clone(candidates), candidate, candidate+1)
+	}
+
+	for i, entry := range entries {
+		for j, candidate := range byTopic[entry.Topic] {
+			if sameMessage(candidate, entry) {
+				take(i, j)
+				break
+			}
+		}
+	}
+	for i, entry := range entries {
+		if matches[i] == nil && len(byTopic[entry.Topic]) > 0 {
+			take(i, 0)
+		}
+	}
+
+	return matches
+}
+
+// sameMessage reports whether an existing message holds what a bundle entry does.
+func sameMessage(current Message, msg BundleMessage) bool {
+	return reflect.DeepEqual(current.Payload, msg.Payload) && current.Frequency == msg.Frequency
+}
+
+func normalizePayload(payload interface{}) (interface{}, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(payloadBytes, &normalized); err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
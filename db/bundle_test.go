@@ -0,0 +1,63 @@
+package db
+
+import "testing"
+
+func TestMatchExisting(t *testing.T) {
+	existing := []Message{
+		{ID: 1, Topic: "a", Payload: "one"},
+		{ID: 2, Topic: "a", Payload: "two"},
+		{ID: 3, Topic: "b", Payload: "three", Frequency: 5},
+	}
+
+	for _, c := range []struct {
+		name    string
+		entries []BundleMessage
+		want    []int // matched ID of each entry, 0 for none
+	}{
+		{
+			name:    "re-import of the same messages",
+			entries: []BundleMessage{{Topic: "a", Payload: "one"}, {Topic: "a", Payload: "two"}, {Topic: "b", Payload: "three", Frequency: 5}},
+			want:    []int{1, 2, 3},
+		},
+		{
+			name:    "identical messages are matched first, whatever the order",
+			entries: []BundleMessage{{Topic: "a", Payload: "two"}, {Topic: "a", Payload: "one"}},
+			want:    []int{2, 1},
+		},
+		{
+			name:    "a changed message conflicts with a message left unmatched",
+			entries: []BundleMessage{{Topic: "a", Payload: "changed"}, {Topic: "a", Payload: "one"}},
+			want:    []int{2, 1},
+		},
+		{
+			name:    "a message is matched once",
+			entries: []BundleMessage{{Topic: "a", Payload: "one"}, {Topic: "a", Payload: "one"}, {Topic: "a", Payload: "one"}},
+			want:    []int{1, 2, 0},
+		},
+		{
+			name:    "messages created by the import are not matched",
+			entries: []BundleMessage{{Topic: "c", Payload: "one"}, {Topic: "c", Payload: "two"}},
+			want:    []int{0, 0},
+		},
+		{
+			name:    "the frequency is compared too",
+			entries: []BundleMessage{{Topic: "b", Payload: "three"}, {Topic: "b", Payload: "three", Frequency: 5}},
+			want:    []int{0, 3},
+		},
+		{
+			name:    "other topics are not matched",
+			entries: []BundleMessage{{Topic: "c", Payload: "one"}},
+			want:    []int{0},
+		},
+	} {
+		for i, match := range matchExisting(existing, c.entries) {
+			got := 0
+			if match != nil {
+				got = match.ID
+			}
+			if got != c.want[i] {
+				t.Errorf("%s: entry %d matched message %d, want %d", c.name, i, got, c.want[i])
+			}
+		}
+	}
+}
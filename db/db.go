@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
@@ -14,6 +15,7 @@ import (
 )
 
 type Message struct {
+	ID        int         `json:"id"`
 	Topic     string      `json:"topic"`
 	Payload   interface{} `json:"payload"`
 	Frequency int         `json:"frequency"`
@@ -24,9 +26,16 @@ type AppRouter struct {
 	DB     *sql.DB
 }
 
-func InitDB(cfg server_config.DB_Config) (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
-		cfg.Server_Address, cfg.Server_Port, cfg.Username, cfg.Database_Name)
+// OpenDB connects to the database without touching the schema.
+func OpenDB(cfg server_config.DB_Config) (*sql.DB, error) {
+	connStr := cfg.DSN
+	if connStr == "" {
+		connStr = fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
+			connValue(cfg.Server_Address), cfg.Server_Port, connValue(cfg.Username), connValue(cfg.Database_Name))
+		if cfg.Password != "" {
+			connStr += " password=" + connValue(cfg.Password)
+		}
+	}
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
@@ -34,19 +43,28 @@ func InitDB(cfg server_config.DB_Config) (*sql.DB, error) {
 	}
 
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS messages (
-            id SERIAL PRIMARY KEY,
-            topic TEXT NOT NULL,
-            payload JSONB,
-			frequency INTEGER NOT NULL DEFAULT 0
-        );
-    `)
+	return db, nil
+}
+
+// connValue quotes a value of a key/value connection string.
+func connValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+}
+
+// InitDB connects to the database and brings its schema up to date.
+func InitDB(cfg server_config.DB_Config) (*sql.DB, error) {
+	db, err := OpenDB(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+		return nil, err
+	}
+
+	if err = MigrateUp(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return db, nil
@@ -88,7 +106,7 @@ func HandlePostRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 func FetchMessages(db *sql.DB) ([]Message, error) {
-	rows, err := db.Query("SELECT topic, payload, frequency FROM messages")
+	rows, err := db.Query("SELECT id, topic, payload, frequency FROM messages WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %w", err)
 	}
@@ -98,7 +116,7 @@ func FetchMessages(db *sql.DB) ([]Message, error) {
 	for rows.Next() {
 		var msg Message
 		var payloadBytes []byte
-		if err := rows.Scan(&msg.Topic, &payloadBytes, &msg.Frequency); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.Topic, &payloadBytes, &msg.Frequency); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
@@ -118,7 +136,7 @@ func FetchMessages(db *sql.DB) ([]Message, error) {
 }
 
 func GetTopics(db *sql.DB) ([]string, error) {
-	rows, err := db.Query("SELECT topic FROM messages")
+	rows, err := db.Query("SELECT DISTINCT topic FROM messages WHERE deleted_at IS NULL ORDER BY topic")
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
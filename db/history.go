@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	PublishOutcomeSuccess = "success"
+	PublishOutcomeError   = "error"
+)
+
+type PublishLogEntry struct {
+	ID          int64     `json:"id"`
+	MessageID   *int      `json:"message_id"`
+	Topic       string    `json:"topic"`
+	Payload     string    `json:"payload"`
+	QoS         byte      `json:"qos"`
+	PublishedAt time.Time `json:"published_at"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type PublishLogQuery struct {
+	Topic string
+	From  time.Time
+	To    time.Time
+	After int64
+	Limit int
+}
+
+// LogPublish records a single publish attempt, successful or not.
+func LogPublish(db *sql.DB, entry PublishLogEntry) error {
+	var publishError sql.NullString
+	if entry.Error != "" {
+		publishError = sql.NullString{String: entry.Error, Valid: true}
+	}
+
+	if entry.PublishedAt.IsZero() {
+		entry.PublishedAt = time.Now()
+	}
+
+	_, err := db.Exec("INSERT INTO publish_log (message_id, topic, payload, qos, published_at, outcome, error) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		entry.MessageID, entry.Topic, []byte(entry.Payload), entry.QoS, entry.PublishedAt, entry.Outcome, publishError)
+	if err != nil {
+		return fmt.Errorf("failed to insert publish log entry: %w", err)
+	}
+
+	return nil
+}
+
+// QueryPublishLog returns up to q.Limit entries in publish order, starting after the entry with ID q.After.
+func QueryPublishLog(db *sql.DB, q PublishLogQuery) ([]PublishLogEntry, error) {
+	conditions := []string{"id > $1"}
+	args := []interface{}{q.After}
+
+	if q.Topic != "" {
+		args = append(args, q.Topic)
+		conditions = append(conditions, fmt.Sprintf("topic = $%d", len(args)))
+	}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		conditions = append(conditions, fmt.Sprintf("published_at >= $%d", len(args)))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		conditions = append(conditions, fmt.Sprintf("published_at < $%d", len(args)))
+	}
+	args = append(args, q.Limit)
+
+	query := fmt.Sprintf("SELECT id, message_id, topic, payload, qos, published_at, outcome, error FROM publish_log WHERE %s ORDER BY id LIMIT $%d",
+		strings.Join(conditions, " AND "), len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query publish log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []PublishLogEntry{}
+	for rows.Next() {
+		var entry PublishLogEntry
+		var messageID sql.NullInt64
+		var payloadBytes []byte
+		var publishError sql.NullString
+		if err := rows.Scan(&entry.ID, &messageID, &entry.Topic, &payloadBytes, &entry.QoS, &entry.PublishedAt, &entry.Outcome, &publishError); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if messageID.Valid {
+			id := int(messageID.Int64)
+			entry.MessageID = &id
+		}
+		entry.Payload = string(payloadBytes)
+		entry.Error = publishError.String
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return entries, nil
+}
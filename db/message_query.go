@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"mqtt-mochi-server/mqtttopic"
+)
+
+// Columns the message list can be sorted on.
+const (
+	MessageSortID        = "id"
+	MessageSortTopic     = "topic"
+	MessageSortFrequency = "frequency"
+)
+
+var ErrInvalidMessageCursor = errors.New("invalid cursor")
+
+// payloadDocument is the full-text document of a payload, its keys and values. The expression matches the
+// index of migration 0007, and must stay identical to it.
+const payloadDocument = `jsonb_to_tsvector('simple', COALESCE(payload, 'null'::jsonb), '["all"]')`
+
+type MessageQuery struct {
+	// TopicFilter is an MQTT filter, e.g. site/+/temp
+	TopicFilter string
+	// Search is a web-style full-text query on the payload: words, "quoted phrases", or and -excluded words
+	Search string
+	// IDs keeps the listed messages only, ExceptIDs leaves them out; nil applies no restriction
+	IDs       []int
+	ExceptIDs []int
+	Sort      string
+	Desc      bool
+	Cursor    string
+	Limit     int
+}
+
+// MessagePage is a page of messages, with the number of messages matching the query over all pages.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	Total      int       `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// messageCursor is the position after the last message of a page, for keyset pagination on the sort column
+// and the ID.
+type messageCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+func (c messageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMessageCursor(cursor, sort string) (messageCursor, error) {
+	var c messageCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, ErrInvalidMessageCursor
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil {
+		return c, ErrInvalidMessageCursor
+	}
+	if c.Sort != sort {
+		return c, fmt.Errorf("%w: it was returned for another sort order", ErrInvalidMessageCursor)
+	}
+
+	switch strings.TrimPrefix(sort, "-") {
+	case MessageSortTopic:
+		if _, ok := c.Value.(string); !ok {
+			return c, ErrInvalidMessageCursor
+		}
+	case MessageSortFrequency:
+		n, ok := c.Value.(json.Number)
+		if !ok {
+			return c, ErrInvalidMessageCursor
+		}
+		frequency, err := n.Int64()
+		if err != nil {
+			return c, ErrInvalidMessageCursor
+		}
+		c.Value = frequency
+	}
+	return c, nil
+}
+
+// QueryMessages returns a page of the live messages matching q, in q.Sort order then by ID. Pages are
+// fetched with keyset pagination, so deep pages cost no more than the first one.
+func QueryMessages(db *sql.DB, q MessageQuery) (MessagePage, error) {
+	page := MessagePage{Messages: []Message{}}
+
+	sort := q.Sort
+	if sort == "" {
+		sort = MessageSortID
+	}
+	if sort != MessageSortID && sort != MessageSortTopic && sort != MessageSortFrequency {
+		return page, fmt.Errorf("unknown sort %q, expected id, topic or frequency", sort)
+	}
+	if q.Desc {
+		sort = "-" + sort
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.TopicFilter != "" {
+		prefix := mqtttopic.Prefix(q.TopicFilter)
+		if prefix == q.TopicFilter {
+			conditions = append(conditions, "topic = "+arg(prefix))
+		} else {
+			// The prefix lets the topic index narrow the rows the regular expression is run on
+			if prefix != "" {
+				conditions = append(conditions, "topic LIKE "+arg(escapeLike(prefix)+"%"))
+			}
+			conditions = append(conditions, "topic ~ "+arg(mqtttopic.Regexp(q.TopicFilter)))
+		}
+	}
+	if q.Search != "" {
+		conditions = append(conditions, payloadDocument+" @@ websearch_to_tsquery('simple', "+arg(q.Search)+")")
+	}
+	if q.IDs != nil {
+		conditions = append(conditions, "id = ANY("+arg(pq.Array(q.IDs))+")")
+	}
+	if q.ExceptIDs != nil {
+		conditions = append(conditions, "NOT (id = ANY("+arg(pq.Array(q.ExceptIDs))+"))")
+	}
+
+	where := strings.Join(conditions, " AND ")
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE "+where, args...).Scan(&page.Total); err != nil {
+		return page, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	column, order, after := strings.TrimPrefix(sort, "-"), "ASC", ">"
+	if q.Desc {
+		order, after = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeMessageCursor(q.Cursor, sort)
+		if err != nil {
+			return page, err
+		}
+		if column == MessageSortID {
+			conditions = append(conditions, "id "+after+" "+arg(cursor.ID))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, after, arg(cursor.Value), arg(cursor.ID)))
+		}
+	}
+
+	orderBy := "id " + order
+	if column != MessageSortID {
+		orderBy = column + " " + order + ", " + orderBy
+	}
+	query := fmt.Sprintf("SELECT id, topic, payload, frequency FROM messages WHERE %s ORDER BY %s LIMIT %s",
+		strings.Join(conditions, " AND "), orderBy, arg(q.Limit))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return page, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg Message
+		var payloadBytes []byte
+		if err := rows.Scan(&msg.ID, &msg.Topic, &payloadBytes, &msg.Frequency); err != nil {
+			return page, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if err := json.Unmarshal(payloadBytes, &msg.Payload); err != nil {
+			return page, fmt.Errorf("failed to unmarshal payload: %w", err)
+		}
+
+		page.Messages = append(page.Messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return page, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if len(page.Messages) == q.Limit {
+		last := page.Messages[len(page.Messages)-1]
+		cursor := messageCursor{Sort: sort, ID: last.ID}
+		switch column {
+		case MessageSortTopic:
+			cursor.Value = last.Topic
+		case MessageSortFrequency:
+			cursor.Value = last.Frequency
+		}
+		page.NextCursor = cursor.encode()
+	}
+
+	return page, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessageCursor(t *testing.T) {
+	for _, c := range []messageCursor{
+		{Sort: MessageSortID, ID: 42},
+		{Sort: "-" + MessageSortID, ID: 1},
+		{Sort: MessageSortTopic, Value: "site/a/temp", ID: 7},
+		{Sort: "-" + MessageSortTopic, Value: "", ID: 7},
+		{Sort: MessageSortFrequency, Value: int64(0), ID: 3},
+		{Sort: "-" + MessageSortFrequency, Value: int64(86400), ID: 3},
+	} {
+		got, err := decodeMessageCursor(c.encode(), c.Sort)
+		if err != nil {
+			t.Errorf("decodeMessageCursor(%+v): %v", c, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("decodeMessageCursor(%+v) = %+v", c, got)
+		}
+	}
+}
+
+func TestMessageCursorErrors(t *testing.T) {
+	raw := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+	for _, c := range []struct {
+		cursor, sort string
+	}{
+		{"not base64!", MessageSortID},
+		{raw(`not json`), MessageSortID},
+		{messageCursor{Sort: MessageSortID, ID: 1}.encode(), MessageSortTopic},
+		{messageCursor{Sort: MessageSortTopic, Value: "a", ID: 1}.encode(), "-" + MessageSortTopic},
+		{raw(`{"s": "topic", "v": 1, "id": 1}`), MessageSortTopic},
+		{raw(`{"s": "frequency", "v": "a", "id": 1}`), MessageSortFrequency},
+		{raw(`{"s": "frequency", "v": 1.5, "id": 1}`), MessageSortFrequency},
+		{raw(`{"s": "frequency", "id": 1}`), MessageSortFrequency},
+	} {
+		if _, err := decodeMessageCursor(c.cursor, c.sort); !errors.Is(err, ErrInvalidMessageCursor) {
+			t.Errorf("decodeMessageCursor(%q, %q) = %v, want ErrInvalidMessageCursor", c.cursor, c.sort, err)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	for s, want := range map[string]string{
+		"site/a/":   "site/a/",
+		"50%_off":   `50\%\_off`,
+		`back\path`: `back\\path`,
+	} {
+		if got := escapeLike(s); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database has migrations applied that this binary does not know about.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// LoadMigrations reads the embedded migrations, named <version>_<name>.up.sql and <version>_<name>.down.sql,
+// and returns them sorted by version.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionStr, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration file name %q", fileName)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", fileName, err)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", fileName, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d has conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return nil
+}
+
+func appliedVersions(db *sql.DB) (map[int]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return applied, nil
+}
+
+// checkSchemaVersion refuses to go on when the database carries a migration this binary does not ship.
+func checkSchemaVersion(applied map[int]time.Time, migrations []Migration) error {
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaTooNew, version, latest)
+		}
+	}
+
+	return nil
+}
+
+func runMigration(db *sql.DB, m Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("failed to run migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return nil
+}
+
+// MigrateUp applies every pending migration, each in its own transaction.
+func MigrateUp(db *sql.DB) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSchemaVersion(applied, migrations); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if err := runMigration(db, m, true); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d_%s\n", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// MigrateDown rolls back the last `steps` applied migrations, newest first.
+func MigrateDown(db *sql.DB, steps int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSchemaVersion(applied, migrations); err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		if m.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+
+		if err := runMigration(db, m, false); err != nil {
+			return err
+		}
+		log.Printf("Reverted migration %d_%s\n", m.Version, m.Name)
+		steps--
+	}
+
+	return nil
+}
+
+// GetMigrationStatus lists every known migration along with the time it was applied, if any.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			s.AppliedAt = &appliedAt
+		}
+		status = append(status, s)
+	}
+
+	return status, checkSchemaVersion(applied, migrations)
+}
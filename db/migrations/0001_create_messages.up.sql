@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS messages (
+    id SERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    payload JSONB,
+    frequency INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,13 @@
+CREATE TABLE publish_log (
+    id BIGSERIAL PRIMARY KEY,
+    message_id INTEGER,
+    topic TEXT NOT NULL,
+    payload BYTEA,
+    qos SMALLINT NOT NULL DEFAULT 0,
+    published_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    outcome TEXT NOT NULL,
+    error TEXT
+);
+
+CREATE INDEX publish_log_published_at_idx ON publish_log (published_at);
+CREATE INDEX publish_log_topic_idx ON publish_log (topic);
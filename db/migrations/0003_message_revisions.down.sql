@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS message_revisions;
+
+DELETE FROM messages WHERE deleted_at IS NOT NULL;
+ALTER TABLE messages DROP COLUMN deleted_at;
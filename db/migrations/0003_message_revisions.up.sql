@@ -0,0 +1,18 @@
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE TABLE message_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    author TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    snapshot JSONB NOT NULL,
+    diff JSONB NOT NULL DEFAULT '[]',
+    UNIQUE (message_id, revision)
+);
+
+-- Existing messages get a baseline revision so they can be restored to their current state.
+INSERT INTO message_revisions (message_id, revision, action, author, snapshot)
+SELECT id, 1, 'create', 'migration', jsonb_build_object('topic', topic, 'payload', payload, 'frequency', frequency)
+FROM messages;
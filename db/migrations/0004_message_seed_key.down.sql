@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS messages_seed_key_idx;
+
+ALTER TABLE messages DROP COLUMN seed_key;
@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN seed_key TEXT;
+
+CREATE UNIQUE INDEX messages_seed_key_idx ON messages (seed_key);
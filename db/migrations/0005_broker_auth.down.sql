@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS broker_acl;
+DROP TABLE IF EXISTS broker_users;
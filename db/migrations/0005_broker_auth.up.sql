@@ -0,0 +1,22 @@
+CREATE TABLE broker_users (
+    id SERIAL PRIMARY KEY,
+    position INTEGER NOT NULL DEFAULT 0,
+    client TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    remote TEXT NOT NULL DEFAULT '',
+    password_hash TEXT,
+    allow BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE broker_acl (
+    id SERIAL PRIMARY KEY,
+    position INTEGER NOT NULL DEFAULT 0,
+    client TEXT NOT NULL DEFAULT '',
+    username TEXT NOT NULL DEFAULT '',
+    remote TEXT NOT NULL DEFAULT '',
+    filters JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS json_schema_bindings;
+DROP TABLE IF EXISTS json_schemas;
@@ -0,0 +1,19 @@
+CREATE TABLE json_schemas (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    schema JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE json_schema_bindings (
+    id SERIAL PRIMARY KEY,
+    schema_id INTEGER NOT NULL REFERENCES json_schemas (id) ON DELETE CASCADE,
+    topic_filter TEXT,
+    message_id INTEGER REFERENCES messages (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((topic_filter IS NULL) <> (message_id IS NULL))
+);
+
+CREATE INDEX json_schema_bindings_schema_id_idx ON json_schema_bindings (schema_id);
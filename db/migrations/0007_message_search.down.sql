@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS messages_payload_search_idx;
+DROP INDEX IF EXISTS messages_live_frequency_idx;
+DROP INDEX IF EXISTS messages_live_topic_pattern_idx;
+DROP INDEX IF EXISTS messages_live_topic_idx;
+DROP INDEX IF EXISTS messages_live_id_idx;
@@ -0,0 +1,10 @@
+-- Indexes for listing, filtering and sorting messages, which must stay responsive with tens of thousands of them.
+CREATE INDEX messages_live_id_idx ON messages (id) WHERE deleted_at IS NULL;
+CREATE INDEX messages_live_topic_idx ON messages (topic, id) WHERE deleted_at IS NULL;
+CREATE INDEX messages_live_topic_pattern_idx ON messages (topic text_pattern_ops) WHERE deleted_at IS NULL;
+CREATE INDEX messages_live_frequency_idx ON messages (frequency, id) WHERE deleted_at IS NULL;
+
+-- The expression must stay identical to the one queried by QueryMessages.
+CREATE INDEX messages_payload_search_idx ON messages
+    USING GIN (jsonb_to_tsvector('simple', COALESCE(payload, 'null'::jsonb), '["all"]'))
+    WHERE deleted_at IS NULL;
@@ -0,0 +1,400 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	RevisionActionCreate  = "create"
+	RevisionActionUpdate  = "update"
+	RevisionActionDelete  = "delete"
+	RevisionActionRestore = "restore"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrRevisionNotFound = errors.New("revision not found")
+)
+
+// MessageSnapshot is the editable state of a message, as stored in each revision.
+type MessageSnapshot struct {
+	Topic     string      `json:"topic"`
+	Payload   interface{} `json:"payload"`
+	Frequency int         `json:"frequency"`
+}
+
+type FieldChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type Revision struct {
+	MessageID int             `json:"message_id"`
+	Revision  int             `json:"revision"`
+	Action    string          `json:"action"`
+	Author    string          `json:"author"`
+	CreatedAt time.Time       `json:"created_at"`
+	Snapshot  MessageSnapshot `json:"snapshot"`
+	Diff      []FieldChange   `json:"diff"`
+}
+
+type TrashedMessage struct {
+	ID        int         `json:"id"`
+	Topic     string      `json:"topic"`
+	Payload   interface{} `json:"payload"`
+	Frequency int         `json:"frequency"`
+	DeletedAt time.Time   `json:"deleted_at"`
+}
+
+// DiffSnapshots lists the fields that changed between two snapshots. Payload objects are compared
+// key by key, so a change deep in the payload is reported with its dotted path.
+func DiffSnapshots(from, to MessageSnapshot) []FieldChange {
+	changes := []FieldChange{}
+	diffValues("topic", from.Topic, to.Topic, &changes)
+	diffValues("payload", from.Payload, to.Payload, &changes)
+	diffValues("frequency", from.Frequency, to.Frequency, &changes)
+	return changes
+}
+
+func diffValues(path string, from, to interface{}, changes *[]FieldChange) {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+
+	if fromIsMap && toIsMap {
+		keys := make([]string, 0, len(fromMap)+len(toMap))
+		for key := range fromMap {
+			keys = append(keys, key)
+		}
+		for key := range toMap {
+			if _, ok := fromMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			diffValues(path+"."+key, fromMap[key], toMap[key], changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, FieldChange{Path: path, From: from, To: to})
+	}
+}
+
+func lockMessage(tx *sql.Tx, id int, deleted bool) (MessageSnapshot, error) {
+	query := "SELECT topic, payload, frequency FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE"
+	if deleted {
+		query = "SELECT topic, payload, frequency FROM messages WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE"
+	}
+
+	var snapshot MessageSnapshot
+	var payloadBytes []byte
+	err := tx.QueryRow(query, id).Scan(&snapshot.Topic, &payloadBytes, &snapshot.Frequency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return snapshot, ErrMessageNotFound
+	}
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to retrieve message: %w", err)
+	}
+
+	if err := json.Unmarshal(payloadBytes, &snapshot.Payload); err != nil {
+		return snapshot, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+func insertRevision(tx *sql.Tx, id int, action string, author string, snapshot MessageSnapshot, diff []FieldChange) (int, error) {
+	snapshotBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	diffBytes, err := json.Marshal(diff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal diff: %w", err)
+	}
+
+	var revision int
+	err = tx.QueryRow(`
+        INSERT INTO message_revisions (message_id, revision, action, author, snapshot, diff)
+        SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5 FROM message_revisions WHERE message_id = $1
+        RETURNING revision
+    `, id, action, author, snapshotBytes, diffBytes).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert revision: %w", err)
+	}
+
+	return revision, nil
+}
+
+func writeMessage(tx *sql.Tx, id int, snapshot MessageSnapshot) error {
+	payloadBytes, err := json.Marshal(snapshot.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE messages SET topic = $1, payload = $2, frequency = $3, deleted_at = NULL WHERE id = $4",
+		snapshot.Topic, payloadBytes, snapshot.Frequency, id)
+	if err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+
+	return nil
+}
+
+// CreateMessage inserts a message and its first revision, returning the new message ID.
+func CreateMessage(db *sql.DB, snapshot MessageSnapshot, author string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id, err := createMessageTx(tx, snapshot, author)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return id, nil
+}
+
+func createMessageTx(tx *sql.Tx, snapshot MessageSnapshot, author string) (int, error) {
+	payloadBytes, err := json.Marshal(snapshot.Payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var id int
+	err = tx.QueryRow("INSERT INTO messages (topic, payload, frequency) VALUES ($1, $2, $3) RETURNING id",
+		snapshot.Topic, payloadBytes, snapshot.Frequency).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert message into database: %w", err)
+	}
+
+	if _, err := insertRevision(tx, id, RevisionActionCreate, author, snapshot, DiffSnapshots(MessageSnapshot{}, snapshot)); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateMessage overwrites a live message and records the change as a new revision.
+func UpdateMessage(db *sql.DB, id int, snapshot MessageSnapshot, author string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateMessageTx(tx, id, snapshot, author); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func updateMessageTx(tx *sql.Tx, id int, snapshot MessageSnapshot, author string) error {
+	current, err := lockMessage(tx, id, false)
+	if err != nil {
+		return err
+	}
+
+	if err := writeMessage(tx, id, snapshot); err != nil {
+		return err
+	}
+
+	_, err = insertRevision(tx, id, RevisionActionUpdate, author, snapshot, DiffSnapshots(current, snapshot))
+	return err
+}
+
+// DeleteMessage moves a message to the trash. It stops being published but keeps its revisions.
+func DeleteMessage(db *sql.DB, id int, author string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := deleteMessageTx(tx, id, author); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func deleteMessageTx(tx *sql.Tx, id int, author string) error {
+	current, err := lockMessage(tx, id, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE messages SET deleted_at = NOW() WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	_, err = insertRevision(tx, id, RevisionActionDelete, author, current, []FieldChange{})
+	return err
+}
+
+// RestoreRevision puts a message back in the state captured by the given revision. Restoring a
+// trashed message also takes it out of the trash.
+func RestoreRevision(db *sql.DB, id int, revision int, author string) (MessageSnapshot, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := lockMessage(tx, id, false)
+	if errors.Is(err, ErrMessageNotFound) {
+		current, err = lockMessage(tx, id, true)
+	}
+	if err != nil {
+		return MessageSnapshot{}, err
+	}
+
+	var snapshotBytes []byte
+	err = tx.QueryRow("SELECT snapshot FROM message_revisions WHERE message_id = $1 AND revision = $2", id, revision).Scan(&snapshotBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MessageSnapshot{}, ErrRevisionNotFound
+	}
+	if err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to retrieve revision: %w", err)
+	}
+
+	var snapshot MessageSnapshot
+	if err := json.Unmarshal(snapshotBytes, &snapshot); err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+
+	if err := writeMessage(tx, id, snapshot); err != nil {
+		return MessageSnapshot{}, err
+	}
+
+	if _, err := insertRevision(tx, id, RevisionActionRestore, author, snapshot, DiffSnapshots(current, snapshot)); err != nil {
+		return MessageSnapshot{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// RestoreFromTrash brings a deleted message back exactly as it was when it was deleted.
+func RestoreFromTrash(db *sql.DB, id int, author string) (MessageSnapshot, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	snapshot, err := lockMessage(tx, id, true)
+	if err != nil {
+		return MessageSnapshot{}, err
+	}
+
+	if _, err := tx.Exec("UPDATE messages SET deleted_at = NULL WHERE id = $1", id); err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to restore message: %w", err)
+	}
+
+	if _, err := insertRevision(tx, id, RevisionActionRestore, author, snapshot, []FieldChange{}); err != nil {
+		return MessageSnapshot{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return MessageSnapshot{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// ListRevisions returns the revisions of a message, newest first.
+func ListRevisions(db *sql.DB, id int) ([]Revision, error) {
+	rows, err := db.Query("SELECT message_id, revision, action, author, created_at, snapshot, diff FROM message_revisions WHERE message_id = $1 ORDER BY revision DESC", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var rev Revision
+		var snapshotBytes, diffBytes []byte
+		if err := rows.Scan(&rev.MessageID, &rev.Revision, &rev.Action, &rev.Author, &rev.CreatedAt, &snapshotBytes, &diffBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if err := json.Unmarshal(snapshotBytes, &rev.Snapshot); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+		}
+		if err := json.Unmarshal(diffBytes, &rev.Diff); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal diff: %w", err)
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	if len(revisions) == 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	return revisions, nil
+}
+
+// ListTrash returns the deleted messages that can still be restored, most recently deleted first.
+func ListTrash(db *sql.DB) ([]TrashedMessage, error) {
+	rows, err := db.Query("SELECT id, topic, payload, frequency, deleted_at FROM messages WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trash: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []TrashedMessage{}
+	for rows.Next() {
+		var msg TrashedMessage
+		var payloadBytes []byte
+		if err := rows.Scan(&msg.ID, &msg.Topic, &payloadBytes, &msg.Frequency, &msg.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if err := json.Unmarshal(payloadBytes, &msg.Payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+		}
+
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrJSONSchemaNotFound        = errors.New("JSON schema not found")
+	ErrJSONSchemaNameTaken       = errors.New("JSON schema name already taken")
+	ErrJSONSchemaBindingNotFound = errors.New("JSON schema binding not found")
+)
+
+// JSONSchema is a registered JSON Schema document, which message payloads are validated against once bound.
+type JSONSchema struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// JSONSchemaBinding applies a schema to the messages whose topic matches TopicFilter, or to one message.
+type JSONSchemaBinding struct {
+	ID          int       `json:"id"`
+	SchemaID    int       `json:"schema_id"`
+	TopicFilter *string   `json:"topic_filter,omitempty"`
+	MessageID   *int      `json:"message_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BoundJSONSchema is a binding along with its schema.
+type BoundJSONSchema struct {
+	JSONSchemaBinding
+	Schema JSONSchema `json:"schema"`
+}
+
+const jsonSchemaColumns = "id, name, description, schema, created_at, updated_at"
+
+const jsonSchemaBindingColumns = "id, schema_id, topic_filter, message_id, created_at"
+
+func scanJSONSchema(row rowScanner) (JSONSchema, error) {
+	var schema JSONSchema
+	var schemaBytes []byte
+	err := row.Scan(&schema.ID, &schema.Name, &schema.Description, &schemaBytes, &schema.CreatedAt, &schema.UpdatedAt)
+	schema.Schema = schemaBytes
+	return schema, err
+}
+
+func scanJSONSchemaBinding(row rowScanner) (JSONSchemaBinding, error) {
+	var binding JSONSchemaBinding
+	var topicFilter sql.NullString
+	var messageID sql.NullInt64
+	if err := row.Scan(&binding.ID, &binding.SchemaID, &topicFilter, &messageID, &binding.CreatedAt); err != nil {
+		return binding, err
+	}
+
+	if topicFilter.Valid {
+		binding.TopicFilter = &topicFilter.String
+	}
+	if messageID.Valid {
+		id := int(messageID.Int64)
+		binding.MessageID = &id
+	}
+
+	return binding, nil
+}
+
+// schemaWriteError tells which constraint a write broke, when it is one the caller can act on.
+func schemaWriteError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch {
+	case pqErr.Code == "23505" && pqErr.Constraint == "json_schemas_name_key":
+		return ErrJSONSchemaNameTaken
+	case pqErr.Code == "23503" && pqErr.Constraint == "json_schema_bindings_schema_id_fkey":
+		return ErrJSONSchemaNotFound
+	case pqErr.Code == "23503" && pqErr.Constraint == "json_schema_bindings_message_id_fkey":
+		return ErrMessageNotFound
+	}
+	return err
+}
+
+func ListJSONSchemas(db *sql.DB) ([]JSONSchema, error) {
+	rows, err := db.Query("SELECT " + jsonSchemaColumns + " FROM json_schemas ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query JSON schemas: %w", err)
+	}
+	defer rows.Close()
+
+	schemas := []JSONSchema{}
+	for rows.Next() {
+		schema, err := scanJSONSchema(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		schemas = append(schemas, schema)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return schemas, nil
+}
+
+func GetJSONSchema(db *sql.DB, id int) (JSONSchema, error) {
+	schema, err := scanJSONSchema(db.QueryRow("SELECT "+jsonSchemaColumns+" FROM json_schemas WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return schema, ErrJSONSchemaNotFound
+	}
+	if err != nil {
+		return schema, fmt.Errorf("failed to retrieve JSON schema: %w", err)
+	}
+	return schema, nil
+}
+
+func CreateJSONSchema(db *sql.DB, schema JSONSchema) (JSONSchema, error) {
+	created, err := scanJSONSchema(db.QueryRow("INSERT INTO json_schemas (name, description, schema) VALUES ($1, $2, $3) RETURNING "+jsonSchemaColumns,
+		schema.Name, schema.Description, []byte(schema.Schema)))
+	if err = schemaWriteError(err); errors.Is(err, ErrJSONSchemaNameTaken) {
+		return schema, err
+	} else if err != nil {
+		return schema, fmt.Errorf("failed to insert JSON schema: %w", err)
+	}
+	return created, nil
+}
+
+func UpdateJSONSchema(db *sql.DB, id int, schema JSONSchema) (JSONSchema, error) {
+	updated, err := scanJSONSchema(db.QueryRow("UPDATE json_schemas SET name = $1, description = $2, schema = $3, updated_at = NOW() WHERE id = $4 RETURNING "+jsonSchemaColumns,
+		schema.Name, schema.Description, []byte(schema.Schema), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return schema, ErrJSONSchemaNotFound
+	}
+	if err = schemaWriteError(err); errors.Is(err, ErrJSONSchemaNameTaken) {
+		return schema, err
+	} else if err != nil {
+		return schema, fmt.Errorf("failed to update JSON schema: %w", err)
+	}
+	return updated, nil
+}
+
+// DeleteJSONSchema deletes a schema along with its bindings.
+func DeleteJSONSchema(db *sql.DB, id int) error {
+	result, err := db.Exec("DELETE FROM json_schemas WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete JSON schema: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrJSONSchemaNotFound
+	}
+
+	return nil
+}
+
+func ListJSONSchemaBindings(db *sql.DB, schemaID int) ([]JSONSchemaBinding, error) {
+	rows, err := db.Query("SELECT "+jsonSchemaBindingColumns+" FROM json_schema_bindings WHERE schema_id = $1 ORDER BY id", schemaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query JSON schema bindings: %w", err)
+	}
+	defer rows.Close()
+
+	bindings := []JSONSchemaBinding{}
+	for rows.Next() {
+		binding, err := scanJSONSchemaBinding(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		bindings = append(bindings, binding)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return bindings, nil
+}
+
+func CreateJSONSchemaBinding(db *sql.DB, binding JSONSchemaBinding) (JSONSchemaBinding, error) {
+	created, err := scanJSONSchemaBinding(db.QueryRow("INSERT INTO json_schema_bindings (schema_id, topic_filter, message_id) VALUES ($1, $2, $3) RETURNING "+jsonSchemaBindingColumns,
+		binding.SchemaID, binding.TopicFilter, binding.MessageID))
+	if err = schemaWriteError(err); errors.Is(err, ErrJSONSchemaNotFound) || errors.Is(err, ErrMessageNotFound) {
+		return binding, err
+	} else if err != nil {
+		return binding, fmt.Errorf("failed to insert JSON schema binding: %w", err)
+	}
+	return created, nil
+}
+
+func DeleteJSONSchemaBinding(db *sql.DB, schemaID int, id int) error {
+	result, err := db.Exec("DELETE FROM json_schema_bindings WHERE id = $1 AND schema_id = $2", id, schemaID)
+	if err != nil {
+		return fmt.Errorf("failed to delete JSON schema binding: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrJSONSchemaBindingNotFound
+	}
+
+	return nil
+}
+
+// ListBoundJSONSchemas returns every binding with its schema, for the caller to pick those that apply.
+func ListBoundJSONSchemas(db *sql.DB) ([]BoundJSONSchema, error) {
+	rows, err := db.Query(`
+        SELECT b.id, b.schema_id, b.topic_filter, b.message_id, b.created_at,
+               s.id, s.name, s.description, s.schema, s.created_at, s.updated_at
+        FROM json_schema_bindings b JOIN json_schemas s ON s.id = b.schema_id
+        ORDER BY b.id
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query JSON schema bindings: %w", err)
+	}
+	defer rows.Close()
+
+	bound := []BoundJSONSchema{}
+	for rows.Next() {
+		var b BoundJSONSchema
+		var topicFilter sql.NullString
+		var messageID sql.NullInt64
+		var schemaBytes []byte
+		if err := rows.Scan(&b.ID, &b.SchemaID, &topicFilter, &messageID, &b.CreatedAt,
+			&b.Schema.ID, &b.Schema.Name, &b.Schema.Description, &schemaBytes, &b.Schema.CreatedAt, &b.Schema.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if topicFilter.Valid {
+			b.TopicFilter = &topicFilter.String
+		}
+		if messageID.Valid {
+			id := int(messageID.Int64)
+			b.MessageID = &id
+		}
+		b.Schema.Schema = schemaBytes
+		bound = append(bound, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return bound, nil
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+const (
+	// SeedModeInsertMissing only creates seed entries that are not in the database yet.
+	SeedModeInsertMissing = "insert-missing"
+	// SeedModeOverwrite makes the database match the seed file, bringing deleted entries back.
+	SeedModeOverwrite = "overwrite"
+	// SeedModeIgnore leaves the database alone.
+	SeedModeIgnore = "ignore"
+)
+
+const seedAuthor = "seed"
+
+type SeedReport struct {
+	Created   int
+	Updated   int
+	Unchanged int
+}
+
+// SeedKey is the stable key a seed entry is stored under: its "key" field when present, its topic otherwise.
+func SeedKey(entry map[string]interface{}) string {
+	if key, ok := entry["key"].(string); ok && key != "" {
+		return key
+	}
+	topic, _ := entry["topic"].(string)
+	return topic
+}
+
+func seedSnapshot(entry map[string]interface{}) (MessageSnapshot, error) {
+	var snapshot MessageSnapshot
+
+	topic, ok := entry["topic"].(string)
+	if !ok || topic == "" {
+		return snapshot, errors.New("missing topic")
+	}
+	snapshot.Topic = topic
+
+	payload, err := normalizePayload(entry["payload"])
+	if err != nil {
+		return snapshot, fmt.Errorf("invalid payload: %w", err)
+	}
+	snapshot.Payload = payload
+
+	if frequency, ok := entry["frequency"]; ok {
+		value, ok := frequency.(float64)
+		if !ok || value < 0 || value != float64(int(value)) {
+			return snapshot, fmt.Errorf("invalid frequency %v", frequency)
+		}
+		snapshot.Frequency = int(value)
+	}
+
+	return snapshot, nil
+}
+
+// Seed upserts the entries of the seed file into the message store, each under its SeedKey.
+func Seed(db *sql.DB, entries []map[string]interface{}, mode string) (SeedReport, error) {
+	var report SeedReport
+
+	switch mode {
+	case SeedModeIgnore:
+		return report, nil
+	case SeedModeInsertMissing, SeedModeOverwrite:
+	default:
+		return report, fmt.Errorf("unknown seed mode %q, expected %s, %s or %s", mode, SeedModeInsertMissing, SeedModeOverwrite, SeedModeIgnore)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return report, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, entry := range entries {
+		snapshot, err := seedSnapshot(entry)
+		if err != nil {
+			return report, fmt.Errorf("seed entry %d: %w", i, err)
+		}
+		key := SeedKey(entry)
+
+		var id int
+		var deleted bool
+		err = tx.QueryRow("SELECT id, deleted_at IS NOT NULL FROM messages WHERE seed_key = $1 FOR UPDATE", key).Scan(&id, &deleted)
+		if errors.Is(err, sql.ErrNoRows) {
+			id, err = createMessageTx(tx, snapshot, seedAuthor)
+			if err != nil {
+				return report, err
+			}
+			if _, err := tx.Exec("UPDATE messages SET seed_key = $1 WHERE id = $2", key, id); err != nil {
+				return report, fmt.Errorf("failed to set seed key: %w", err)
+			}
+			report.Created++
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to look up seed entry %q: %w", key, err)
+		}
+
+		if mode == SeedModeInsertMissing {
+			report.Unchanged++
+			continue
+		}
+
+		current, err := lockMessage(tx, id, deleted)
+		if err != nil {
+			return report, err
+		}
+
+		if !deleted && reflect.DeepEqual(current, snapshot) {
+			report.Unchanged++
+			continue
+		}
+
+		if err := writeMessage(tx, id, snapshot); err != nil {
+			return report, err
+		}
+
+		action := RevisionActionUpdate
+		if deleted {
+			action = RevisionActionRestore
+		}
+		if _, err := insertRevision(tx, id, action, seedAuthor, snapshot, DiffSnapshots(current, snapshot)); err != nil {
+			return report, err
+		}
+		report.Updated++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return report, nil
+}
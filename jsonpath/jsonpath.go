@@ -0,0 +1,227 @@
+// Package jsonpath evaluates the small subset of JSONPath used to match message payloads: a path such as
+// $.sensors[0].temp, $.tags[*] or $['site id'], optionally compared to a JSON literal, e.g. $.temp > 20 or
+// $.state == "on". A path alone matches when it exists.
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+	path  []segment
+	op    string
+	value interface{}
+}
+
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Compile parses an expression.
+func Compile(expr string) (*Expr, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("invalid expression %q: must start with $", expr)
+	}
+
+	pathText, op, literal := expr, "", ""
+	if i, found := findOperator(expr); found != "" {
+		pathText, op, literal = strings.TrimSpace(expr[:i]), found, strings.TrimSpace(expr[i+len(found):])
+	}
+
+	e := &Expr{op: op}
+	var err error
+	if e.path, err = parsePath(pathText[1:]); err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+
+	if op != "" {
+		if literal == "" {
+			return nil, fmt.Errorf("invalid expression %q: missing value after %s", expr, op)
+		}
+		if strings.HasPrefix(literal, "'") && strings.HasSuffix(literal, "'") && len(literal) > 1 {
+			e.value = literal[1 : len(literal)-1]
+		} else if err := json.Unmarshal([]byte(literal), &e.value); err != nil {
+			return nil, fmt.Errorf("invalid expression %q: value %s is not a JSON literal", expr, literal)
+		}
+	}
+
+	return e, nil
+}
+
+// findOperator returns the position of the first comparison operator outside of quotes and brackets.
+func findOperator(expr string) (int, string) {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			for _, op := range operators {
+				if strings.HasPrefix(expr[i:], op) {
+					return i, op
+				}
+			}
+		}
+	}
+	return -1, ""
+}
+
+func parsePath(text string) ([]segment, error) {
+	var path []segment
+	for len(text) > 0 {
+		switch text[0] {
+		case '.':
+			text = text[1:]
+			end := strings.IndexAny(text, ".[")
+			if end == -1 {
+				end = len(text)
+			}
+			key := text[:end]
+			if key == "" {
+				return nil, fmt.Errorf("empty key")
+			}
+			path = append(path, segment{key: key, wildcard: key == "*"})
+			text = text[end:]
+		case '[':
+			end := strings.IndexByte(text, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("missing ]")
+			}
+			inner := strings.TrimSpace(text[1:end])
+			switch {
+			case inner == "*":
+				path = append(path, segment{wildcard: true})
+			case len(inner) > 1 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				path = append(path, segment{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index [%s]", inner)
+				}
+				path = append(path, segment{index: index, isIndex: true})
+			}
+			text = text[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", text[0])
+		}
+	}
+	return path, nil
+}
+
+// Match reports whether the decoded JSON value has a node at the path that satisfies the comparison. Paths
+// with wildcards match when any of their nodes does.
+func (e *Expr) Match(value interface{}) bool {
+	for _, node := range resolve(value, e.path) {
+		if e.op == "" || compare(node, e.op, e.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchJSON decodes data and matches it. Data that is not JSON never matches.
+func (e *Expr) MatchJSON(data []byte) bool {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return false
+	}
+	return e.Match(value)
+}
+
+func resolve(value interface{}, path []segment) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+
+	seg, rest := path[0], path[1:]
+	var nodes []interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if seg.wildcard {
+			for _, item := range v {
+				nodes = append(nodes, resolve(item, rest)...)
+			}
+		} else if item, ok := v[seg.key]; ok && !seg.isIndex {
+			nodes = resolve(item, rest)
+		}
+	case []interface{}:
+		if seg.wildcard {
+			for _, item := range v {
+				nodes = append(nodes, resolve(item, rest)...)
+			}
+		} else if seg.isIndex {
+			i := seg.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				nodes = resolve(v[i], rest)
+			}
+		}
+	}
+	return nodes
+}
+
+func compare(node interface{}, op string, value interface{}) bool {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(node, value)
+	case "!=":
+		return !reflect.DeepEqual(node, value)
+	}
+
+	var c int
+	switch a := node.(type) {
+	case float64:
+		b, ok := value.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < b:
+			c = -1
+		case a > b:
+			c = 1
+		}
+	case string:
+		b, ok := value.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(a, b)
+	default:
+		return false
+	}
+
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
@@ -0,0 +1,93 @@
+package jsonpath
+
+import "testing"
+
+const payload = `{
+	"temp": 21.5,
+	"state": "on",
+	"site id": "north",
+	"sensors": [{"temp": 18}, {"temp": 25}],
+	"tags": ["a", "b"],
+	"meta": {"ok": true, "owner": null}
+}`
+
+func TestMatchJSON(t *testing.T) {
+	for expr, want := range map[string]bool{
+		`$`:                                     true,
+		`$.temp`:                                true,
+		`$.missing`:                             false,
+		`$.temp > 20`:                           true,
+		`$.temp >= 21.5`:                        true,
+		`$.temp < 21.5`:                         false,
+		`$.temp <= 21.5`:                        true,
+		`$.temp == 21.5`:                        true,
+		`$.temp != 21.5`:                        false,
+		`$.state == "on"`:                       true,
+		`$.state == 'on'`:                       true,
+		`$.state > "a"`:                         true,
+		`$.state > 1`:                           false,
+		`$['site id'] == "north"`:               true,
+		`$["site id"]`:                          true,
+		`$.sensors[0].temp`:                     true,
+		`$.sensors[1].temp > 20`:                true,
+		`$.sensors[0].temp > 20`:                false,
+		`$.sensors[-1].temp == 25`:              true,
+		`$.sensors[2]`:                          false,
+		`$.sensors[*].temp > 20`:                true,
+		`$.sensors[*].temp > 30`:                false,
+		`$.tags[*] == "b"`:                      true,
+		`$.tags.a`:                              false,
+		`$.meta.*`:                              true,
+		`$.meta.ok == true`:                     true,
+		`$.meta.owner == null`:                  true,
+		`$.meta == {"ok": true, "owner": null}`: true,
+	} {
+		e, err := Compile(expr)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", expr, err)
+			continue
+		}
+		if got := e.MatchJSON([]byte(payload)); got != want {
+			t.Errorf("%s: MatchJSON = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestMatchJSONNotJSON(t *testing.T) {
+	e, err := Compile(`$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.MatchJSON([]byte("not json")) {
+		t.Error("a payload that is not JSON matched")
+	}
+}
+
+// Operators in quoted keys and literals are part of them
+func TestCompileQuoted(t *testing.T) {
+	e, err := Compile(`$['a>b'] == "x<y"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.MatchJSON([]byte(`{"a>b": "x<y"}`)) {
+		t.Error("quoted operators were not kept in the key and the value")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`temp > 20`,
+		`$.`,
+		`$..temp`,
+		`$.tags[0`,
+		`$.tags[x]`,
+		`$temp`,
+		`$.temp >`,
+		`$.temp > hot`,
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// generateAttempts bounds the retries for a value that validates, as keywords such as not, oneOf or
+	// uniqueItems are only checked after generating
+	generateAttempts = 20
+	// maxGenerateDepth stops recursive schemas
+	maxGenerateDepth = 16
+	// defaultSpread is the width of the range of unbounded numbers and the extra items of arrays
+	defaultSpread = 100
+	// maxGeneratedSize caps the length of strings and the size of arrays and objects, whatever the schema
+	// asks for, so that a schema can't make each publish allocate gigabytes
+	maxGeneratedSize = 1024
+)
+
+// Generate returns a random value that conforms to the schema. Optional properties are included at random,
+// and unbounded numbers, strings and arrays are kept small. When the schema can't be satisfied after a few
+// attempts, the last value is returned along with its violations.
+func (s *Schema) Generate() (interface{}, []ValidationError, error) {
+	var value interface{}
+	var errs []ValidationError
+	for attempt := 0; attempt < generateAttempts; attempt++ {
+		var err error
+		if value, err = s.generate(0); err != nil {
+			return nil, nil, err
+		}
+		if errs = s.Validate(value); len(errs) == 0 {
+			return value, errs, nil
+		}
+	}
+	return value, errs, nil
+}
+
+// Field returns the schema of the property at path, following $ref and allOf, or nil when the schema does not
+// describe it.
+func (s *Schema) Field(path []string) *Schema {
+	if s == nil || len(path) == 0 {
+		return s
+	}
+	if s.ref != nil {
+		if sub := s.ref.Field(path); sub != nil {
+			return sub
+		}
+	}
+	if sub, ok := s.Properties[path[0]]; ok {
+		return sub.Field(path[1:])
+	}
+	for _, sub := range s.AllOf {
+		if found := sub.Field(path); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (s *Schema) generate(depth int) (interface{}, error) {
+	if depth > maxGenerateDepth {
+		return nil, fmt.Errorf("schema nested deeper than %d levels", maxGenerateDepth)
+	}
+	if s.boolean != nil {
+		if !*s.boolean {
+			return nil, fmt.Errorf("the false schema accepts no value")
+		}
+		return randomWord(4, 8), nil
+	}
+
+	if s.HasConst {
+		return s.Const, nil
+	}
+	if len(s.Enum) > 0 {
+		return s.Enum[rand.IntN(len(s.Enum))], nil
+	}
+
+	// Branches are generated on their own when this schema adds nothing to them
+	if s.own() {
+		if s.ref != nil {
+			return s.ref.generate(depth + 1)
+		}
+		for _, branches := range [][]*Schema{s.OneOf, s.AnyOf} {
+			if len(branches) > 0 {
+				return branches[rand.IntN(len(branches))].generate(depth + 1)
+			}
+		}
+		if len(s.AllOf) > 0 {
+			return s.generateAll(depth)
+		}
+	}
+
+	switch s.pickType() {
+	case "null":
+		return nil, nil
+	case "boolean":
+		return rand.IntN(2) == 1, nil
+	case "object":
+		return s.generateObject(depth)
+	case "array":
+		return s.generateArray(depth)
+	case "integer":
+		return s.generateNumber(true), nil
+	case "number":
+		return s.generateNumber(false), nil
+	default:
+		return s.generateString(), nil
+	}
+}
+
+// own reports whether the schema has no constraint of its own beside $ref and the combinators.
+func (s *Schema) own() bool {
+	return len(s.Type) == 0 && s.Properties == nil && s.Items == nil && s.PrefixItems == nil &&
+		s.Minimum == nil && s.Maximum == nil && s.MinLength == nil && s.MaxLength == nil && s.Pattern == "" && s.Format == ""
+}
+
+// generateAll merges the objects generated by each schema of allOf, or returns the last value otherwise.
+func (s *Schema) generateAll(depth int) (interface{}, error) {
+	var merged map[string]interface{}
+	var last interface{}
+	for _, sub := range s.AllOf {
+		value, err := sub.generate(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			last = value
+			continue
+		}
+		if merged == nil {
+			merged = make(map[string]interface{})
+		}
+		for key, item := range object {
+			merged[key] = item
+		}
+	}
+	if merged != nil {
+		return merged, nil
+	}
+	return last, nil
+}
+
+// pickType returns one of the allowed types, preferring anything to null, or guesses it from the keywords.
+func (s *Schema) pickType() string {
+	types := make([]string, 0, len(s.Type))
+	for _, t := range s.Type {
+		if t != "null" {
+			types = append(types, t)
+		}
+	}
+	if len(types) > 0 {
+		return types[rand.IntN(len(types))]
+	}
+	if len(s.Type) > 0 {
+		return "null"
+	}
+
+	switch {
+	case s.Properties != nil || s.Required != nil || s.AdditionalProperties != nil:
+		return "object"
+	case s.Items != nil || s.PrefixItems != nil || s.MinItems != nil || s.MaxItems != nil:
+		return "array"
+	case s.Minimum != nil || s.Maximum != nil || s.ExclusiveMinimum != nil || s.ExclusiveMaximum != nil || s.MultipleOf != nil:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func (s *Schema) generateObject(depth int) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	required := make(map[string]bool, len(s.Required))
+	for _, name := range s.Required {
+		required[name] = true
+	}
+
+	minProperties := 0
+	if s.MinProperties != nil {
+		minProperties = min(*s.MinProperties, maxGeneratedSize)
+	}
+
+	for name, sub := range s.Properties {
+		if !required[name] && rand.IntN(2) == 0 && len(s.Properties)-len(object) > minProperties {
+			continue
+		}
+		value, err := sub.generate(depth + 1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		object[name] = value
+	}
+
+	// Required properties without a schema of their own, and the extra ones needed to reach minProperties
+	for name := range required {
+		if _, ok := object[name]; !ok {
+			value, err := s.additional(depth)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			object[name] = value
+		}
+	}
+	for i := 0; len(object) < minProperties; i++ {
+		value, err := s.additional(depth)
+		if err != nil {
+			return nil, err
+		}
+		object["property"+strconv.Itoa(i)] = value
+	}
+
+	return object, nil
+}
+
+func (s *Schema) additional(depth int) (interface{}, error) {
+	if s.AdditionalProperties != nil && s.AdditionalProperties.boolean == nil {
+		return s.AdditionalProperties.generate(depth + 1)
+	}
+	return randomWord(4, 8), nil
+}
+
+func (s *Schema) generateArray(depth int) ([]interface{}, error) {
+	minItems, maxItems := 0, -1
+	if s.MinItems != nil {
+		minItems = min(*s.MinItems, maxGeneratedSize)
+	}
+	if s.MaxItems != nil {
+		maxItems = min(*s.MaxItems, maxGeneratedSize)
+	}
+	if minItems < len(s.PrefixItems) {
+		minItems = len(s.PrefixItems)
+	}
+	if s.Contains != nil && minItems == 0 {
+		minItems = 1
+	}
+	if maxItems < 0 {
+		maxItems = minItems + 3
+	}
+	if s.PrefixItems != nil && (s.Items == nil || s.Items.boolean != nil && !*s.Items.boolean) {
+		maxItems = len(s.PrefixItems)
+	}
+	if maxItems < minItems {
+		maxItems = minItems
+	}
+	maxItems = min(maxItems, maxGeneratedSize)
+	minItems = min(minItems, maxItems)
+
+	length := minItems + rand.IntN(maxItems-minItems+1)
+	array := make([]interface{}, 0, length)
+	for i := 0; i < length; i++ {
+		sub := s.Items
+		if i < len(s.PrefixItems) {
+			sub = s.PrefixItems[i]
+		} else if i == len(s.PrefixItems) && s.Contains != nil {
+			sub = s.Contains
+		}
+
+		var value interface{}
+		var err error
+		if sub == nil {
+			value = randomWord(4, 8)
+		} else if value, err = sub.generate(depth + 1); err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+		array = append(array, value)
+	}
+
+	return array, nil
+}
+
+func (s *Schema) generateNumber(integer bool) interface{} {
+	low, high := math.Inf(-1), math.Inf(1)
+	if s.Minimum != nil {
+		low = *s.Minimum
+	}
+	if s.ExclusiveMinimum != nil && *s.ExclusiveMinimum >= low {
+		low = *s.ExclusiveMinimum + exclusiveStep(integer)
+	}
+	if s.Maximum != nil {
+		high = *s.Maximum
+	}
+	if s.ExclusiveMaximum != nil && *s.ExclusiveMaximum <= high {
+		high = *s.ExclusiveMaximum - exclusiveStep(integer)
+	}
+
+	switch {
+	case math.IsInf(low, -1) && math.IsInf(high, 1):
+		low, high = 0, defaultSpread
+	case math.IsInf(low, -1):
+		low = high - defaultSpread
+	case math.IsInf(high, 1):
+		high = low + defaultSpread
+	}
+	if high < low {
+		high = low
+	}
+
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		step := *s.MultipleOf
+		first, last := math.Ceil(low/step), math.Floor(high/step)
+		if last < first {
+			last = first
+		}
+		n := randomInteger(first, last) * step
+		if integer {
+			return integerValue(math.Round(n))
+		}
+		return n
+	}
+
+	if integer {
+		first, last := math.Ceil(low), math.Floor(high)
+		if last < first {
+			last = first
+		}
+		return integerValue(randomInteger(first, last))
+	}
+
+	// Two decimals read like sensor values
+	n := math.Round((low+rand.Float64()*(high-low))*100) / 100
+	return math.Min(math.Max(n, low), high)
+}
+
+// randomInteger returns a whole number between first and last, both whole. Ranges too wide to count in an
+// int64 are sampled as floats.
+func randomInteger(first, last float64) float64 {
+	span := last - first
+	if span < 1<<53 {
+		return first + float64(rand.Int64N(int64(span)+1))
+	}
+	return math.Min(first+math.Floor(rand.Float64()*span), last)
+}
+
+// integerValue returns n as an int64, unless it is out of the int64 range.
+func integerValue(n float64) interface{} {
+	if n >= -(1<<63) && n < 1<<63 {
+		return int64(n)
+	}
+	return n
+}
+
+func exclusiveStep(integer bool) float64 {
+	if integer {
+		return 1
+	}
+	return 0.01
+}
+
+func (s *Schema) generateString() string {
+	if s.Format != "" {
+		if value, ok := generateFormat(s.Format); ok {
+			return value
+		}
+	}
+	if s.pattern != nil {
+		if value, ok := generatePattern(s.Pattern); ok {
+			return value
+		}
+	}
+
+	minLength, maxLength := 4, 12
+	if s.MinLength != nil {
+		minLength = min(*s.MinLength, maxGeneratedSize)
+		if maxLength < minLength {
+			maxLength = minLength + 8
+		}
+	}
+	if s.MaxLength != nil {
+		maxLength = *s.MaxLength
+		if minLength > maxLength {
+			minLength = maxLength
+		}
+	}
+	return randomWord(minLength, min(maxLength, maxGeneratedSize))
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyz"
+
+func randomWord(minLength, maxLength int) string {
+	if maxLength < minLength {
+		maxLength = minLength
+	}
+	b := make([]byte, minLength+rand.IntN(maxLength-minLength+1))
+	for i := range b {
+		b[i] = letters[rand.IntN(len(letters))]
+	}
+	return string(b)
+}
+
+func generateFormat(format string) (string, bool) {
+	now := time.Now().UTC().Add(-time.Duration(rand.Int64N(int64(24 * time.Hour))))
+	switch format {
+	case "date-time":
+		return now.Format(time.RFC3339), true
+	case "date":
+		return now.Format(time.DateOnly), true
+	case "time":
+		return now.Format("15:04:05Z07:00"), true
+	case "email":
+		return randomWord(4, 8) + "@example.com", true
+	case "hostname":
+		return randomWord(4, 8) + ".example.com", true
+	case "ipv4":
+		return fmt.Sprintf("10.%d.%d.%d", rand.IntN(256), rand.IntN(256), 1+rand.IntN(254)), true
+	case "ipv6":
+		return fmt.Sprintf("fd00::%x:%x", rand.IntN(0x10000), rand.IntN(0x10000)), true
+	case "uri":
+		return "https://example.com/" + randomWord(4, 8), true
+	case "uuid":
+		b := make([]byte, 16)
+		for i := range b {
+			b[i] = byte(rand.IntN(256))
+		}
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), true
+	}
+	return "", false
+}
+
+// generatePattern returns a string matching a regular expression. Unbounded repeats are kept short.
+func generatePattern(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	var b strings.Builder
+	if !generateRegexp(re.Simplify(), &b) {
+		return "", false
+	}
+	return b.String(), true
+}
+
+func generateRegexp(re *syntax.Regexp, b *strings.Builder) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+		return true
+	case syntax.OpCharClass:
+		// Rune holds inclusive ranges, pairs of low and high
+		var total int
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			total += int(re.Rune[i+1]-re.Rune[i]) + 1
+		}
+		if total == 0 {
+			return false
+		}
+		n := rand.IntN(total)
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			size := int(re.Rune[i+1]-re.Rune[i]) + 1
+			if n < size {
+				b.WriteRune(re.Rune[i] + rune(n))
+				return true
+			}
+			n -= size
+		}
+		return false
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteByte(letters[rand.IntN(len(letters))])
+		return true
+	case syntax.OpCapture:
+		return generateRegexp(re.Sub[0], b)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !generateRegexp(sub, b) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		return generateRegexp(re.Sub[rand.IntN(len(re.Sub))], b)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		low, high := 0, 3
+		switch re.Op {
+		case syntax.OpPlus:
+			low = 1
+		case syntax.OpQuest:
+			high = 1
+		case syntax.OpRepeat:
+			low, high = re.Min, re.Max
+			if high < 0 {
+				high = low + 3
+			}
+		}
+		for i := low + rand.IntN(high-low+1); i > 0; i-- {
+			if !generateRegexp(re.Sub[0], b) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
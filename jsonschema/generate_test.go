@@ -0,0 +1,74 @@
+package jsonschema
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "integer", "minimum": 0, "maximum": 1e19}`,
+		`{"type": "integer", "minimum": -1e300, "maximum": 1e300}`,
+		`{"type": "integer", "minimum": 1e19, "maximum": 1e19}`,
+		`{"type": "integer", "minimum": 0, "maximum": 1e19, "multipleOf": 3}`,
+		`{"type": "number", "minimum": 0, "maximum": 1e19, "multipleOf": 0.5}`,
+		`{"type": "integer", "exclusiveMinimum": 5, "exclusiveMaximum": 7}`,
+		`{"type": "string", "minLength": 3, "maxLength": 5}`,
+		`{"type": "string", "maxLength": 1000000000}`,
+		`{"type": "string", "pattern": "^sensor-[0-9]{3}$"}`,
+		`{"type": "string", "format": "uuid"}`,
+		`{"type": "array", "items": {"type": "boolean"}, "maxItems": 1000000000}`,
+		`{"type": "array", "prefixItems": [{"const": "a"}, {"type": "integer"}], "items": false}`,
+		`{"type": "object", "properties": {"id": {"type": "integer"}}, "required": ["id", "name"]}`,
+		`{"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}}}}, "$ref": "#/$defs/node"}`,
+		`{"oneOf": [{"type": "integer", "maximum": 0}, {"type": "integer", "minimum": 10, "maximum": 20}]}`,
+	} {
+		s := compile(t, schema)
+		for i := 0; i < 20; i++ {
+			value, errs, err := s.Generate()
+			if err != nil || len(errs) > 0 {
+				t.Errorf("Generate(%s) = %v, %v, %v", schema, value, errs, err)
+				break
+			}
+		}
+	}
+}
+
+// Sizes past maxGeneratedSize can't be generated, but must not be allocated either
+func TestGenerateHugeSizes(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "string", "minLength": 1000000000}`,
+		`{"type": "array", "minItems": 1000000000}`,
+		`{"type": "object", "minProperties": 1000000000}`,
+	} {
+		value, errs, err := compile(t, schema).Generate()
+		if err != nil {
+			t.Errorf("Generate(%s): %v", schema, err)
+			continue
+		}
+		if len(errs) == 0 {
+			t.Errorf("Generate(%s) conforms, want violations", schema)
+		}
+		if size := len(fmt.Sprint(value)); size > 100*maxGeneratedSize {
+			t.Errorf("Generate(%s) returned %d bytes", schema, size)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	s := compile(t, `{
+		"$defs": {"device": {"type": "object", "properties": {"id": {"type": "string"}}}},
+		"allOf": [{"properties": {"device": {"$ref": "#/$defs/device"}}}],
+		"properties": {"temp": {"type": "number"}}
+	}`)
+	for path, want := range map[string]string{"temp": "number", "device.id": "string"} {
+		field := s.Field(strings.Split(path, "."))
+		if field == nil || len(field.Type) != 1 || field.Type[0] != want {
+			t.Errorf("Field(%s) = %+v, want type %s", path, field, want)
+		}
+	}
+	if s.Field([]string{"missing"}) != nil {
+		t.Error("Field(missing) should be nil")
+	}
+}
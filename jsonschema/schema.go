@@ -0,0 +1,397 @@
+// Package jsonschema validates decoded JSON values against the JSON Schema keywords used to describe message
+// payloads: type, enum, const, the object, array, number and string constraints, common formats, allOf,
+// anyOf, oneOf, not, if/then/else, and $ref to the same document, e.g. "#/$defs/reading". It also generates
+// random values that conform to a schema, for the simulator to publish.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Schema is a compiled schema. A boolean schema, true or false, accepts everything or nothing.
+type Schema struct {
+	Ref         string             `json:"$ref"`
+	Defs        map[string]*Schema `json:"$defs"`
+	Definitions map[string]*Schema `json:"definitions"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Type        Types              `json:"type"`
+	Enum        []interface{}      `json:"enum"`
+	Const       interface{}        `json:"-"`
+	HasConst    bool               `json:"-"`
+	Default     interface{}        `json:"default"`
+	Examples    []interface{}      `json:"examples"`
+
+	Properties           map[string]*Schema `json:"properties"`
+	PatternProperties    map[string]*Schema `json:"patternProperties"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	MinProperties        *int               `json:"minProperties"`
+	MaxProperties        *int               `json:"maxProperties"`
+
+	// Items applies to the array items past PrefixItems. Draft-07 tuples, "items" given as an array, are read
+	// into PrefixItems, with "additionalItems" into Items.
+	Items       *Schema   `json:"-"`
+	PrefixItems []*Schema `json:"prefixItems"`
+	MinItems    *int      `json:"minItems"`
+	MaxItems    *int      `json:"maxItems"`
+	UniqueItems bool      `json:"uniqueItems"`
+	Contains    *Schema   `json:"contains"`
+
+	Minimum          *float64 `json:"minimum"`
+	Maximum          *float64 `json:"maximum"`
+	ExclusiveMinimum *float64 `json:"-"`
+	ExclusiveMaximum *float64 `json:"-"`
+	MultipleOf       *float64 `json:"multipleOf"`
+
+	MinLength *int   `json:"minLength"`
+	MaxLength *int   `json:"maxLength"`
+	Pattern   string `json:"pattern"`
+	Format    string `json:"format"`
+
+	AllOf []*Schema `json:"allOf"`
+	AnyOf []*Schema `json:"anyOf"`
+	OneOf []*Schema `json:"oneOf"`
+	Not   *Schema   `json:"not"`
+	If    *Schema   `json:"if"`
+	Then  *Schema   `json:"then"`
+	Else  *Schema   `json:"else"`
+
+	boolean  *bool
+	root     *Schema
+	ref      *Schema
+	pattern  *regexp.Regexp
+	patterns map[string]*regexp.Regexp
+}
+
+// Types is the "type" keyword, a single type name or a list of them.
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = Types{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = names
+	return nil
+}
+
+var typeNames = map[string]bool{"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*s = Schema{boolean: &b}
+		return nil
+	}
+
+	type plain Schema
+	raw := struct {
+		*plain
+		Items            json.RawMessage `json:"items"`
+		AdditionalItems  *Schema         `json:"additionalItems"`
+		Const            json.RawMessage `json:"const"`
+		ExclusiveMinimum json.RawMessage `json:"exclusiveMinimum"`
+		ExclusiveMaximum json.RawMessage `json:"exclusiveMaximum"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if len(raw.Items) > 0 {
+		if raw.Items[0] == '[' {
+			if err := json.Unmarshal(raw.Items, &s.PrefixItems); err != nil {
+				return err
+			}
+			s.Items = raw.AdditionalItems
+		} else if err := json.Unmarshal(raw.Items, &s.Items); err != nil {
+			return err
+		}
+	}
+
+	if len(raw.Const) > 0 {
+		if err := json.Unmarshal(raw.Const, &s.Const); err != nil {
+			return err
+		}
+		s.HasConst = true
+	}
+
+	var err error
+	if s.ExclusiveMinimum, err = exclusiveBound(raw.ExclusiveMinimum, s.Minimum); err != nil {
+		return fmt.Errorf("exclusiveMinimum: %w", err)
+	}
+	if s.ExclusiveMinimum != nil && s.Minimum == s.ExclusiveMinimum {
+		s.Minimum = nil
+	}
+	if s.ExclusiveMaximum, err = exclusiveBound(raw.ExclusiveMaximum, s.Maximum); err != nil {
+		return fmt.Errorf("exclusiveMaximum: %w", err)
+	}
+	if s.ExclusiveMaximum != nil && s.Maximum == s.ExclusiveMaximum {
+		s.Maximum = nil
+	}
+
+	return nil
+}
+
+// exclusiveBound reads an exclusive bound, either a number or, as in draft-04, true to make bound exclusive.
+func exclusiveBound(data json.RawMessage, bound *float64) (*float64, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var exclusive bool
+	if err := json.Unmarshal(data, &exclusive); err == nil {
+		if exclusive {
+			return bound, nil
+		}
+		return nil, nil
+	}
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &value, nil
+}
+
+// Compile parses a schema document.
+func Compile(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.compile(&s, "#"); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &s, nil
+}
+
+func (s *Schema) compile(root *Schema, location string) error {
+	s.root = root
+	if s.boolean != nil {
+		return nil
+	}
+
+	for _, name := range s.Type {
+		if !typeNames[name] {
+			return fmt.Errorf("%s: unknown type %q", location, name)
+		}
+	}
+
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", location, err)
+		}
+	}
+	if len(s.PatternProperties) > 0 {
+		s.patterns = make(map[string]*regexp.Regexp, len(s.PatternProperties))
+		for pattern := range s.PatternProperties {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern property: %w", location, err)
+			}
+			s.patterns[pattern] = re
+		}
+	}
+
+	for _, sub := range s.subschemas(location) {
+		if sub.schema == nil {
+			continue
+		}
+		if err := sub.schema.compile(root, sub.location); err != nil {
+			return err
+		}
+	}
+
+	// Refs are resolved last, once the whole document is compiled
+	if s == root {
+		if err := root.resolveRefs("#"); err != nil {
+			return err
+		}
+		return root.checkLoops("#", map[*Schema]int{})
+	}
+	return nil
+}
+
+func (s *Schema) resolveRefs(location string) error {
+	if s.Ref != "" {
+		target, err := s.root.lookup(s.Ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", location, err)
+		}
+		s.ref = target
+	}
+	for _, sub := range s.subschemas(location) {
+		if sub.schema == nil {
+			continue
+		}
+		if err := sub.schema.resolveRefs(sub.location); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loop states of checkLoops
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// checkLoops rejects $ref chains that lead back to a schema applied to the same value, such as {"$ref": "#"},
+// which would never stop validating. Loops through a property or an item are fine, since each step goes
+// deeper into the value.
+func (s *Schema) checkLoops(location string, states map[*Schema]int) error {
+	if err := s.checkLoop(location, states); err != nil {
+		return err
+	}
+	for _, sub := range s.subschemas(location) {
+		if sub.schema == nil {
+			continue
+		}
+		if err := sub.schema.checkLoops(sub.location, states); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) checkLoop(location string, states map[*Schema]int) error {
+	switch states[s] {
+	case visiting:
+		return fmt.Errorf("%s: $ref loops back to a schema of the same value, without going into a property or item", location)
+	case visited:
+		return nil
+	}
+
+	states[s] = visiting
+	for _, sub := range s.sameValue(location) {
+		if sub.schema == nil {
+			continue
+		}
+		if err := sub.schema.checkLoop(sub.location, states); err != nil {
+			return err
+		}
+	}
+	states[s] = visited
+	return nil
+}
+
+// sameValue lists the schemas applied to the very value s is applied to: its $ref target and combinators.
+func (s *Schema) sameValue(location string) []subschema {
+	subs := []subschema{{location + "/$ref", s.ref}}
+	for _, keyword := range []struct {
+		name    string
+		schemas []*Schema
+	}{{"allOf", s.AllOf}, {"anyOf", s.AnyOf}, {"oneOf", s.OneOf}} {
+		for i, schema := range keyword.schemas {
+			subs = append(subs, subschema{location + "/" + keyword.name + "/" + strconv.Itoa(i), schema})
+		}
+	}
+	for _, keyword := range []struct {
+		name   string
+		schema *Schema
+	}{{"not", s.Not}, {"if", s.If}, {"then", s.Then}, {"else", s.Else}} {
+		subs = append(subs, subschema{location + "/" + keyword.name, keyword.schema})
+	}
+	return subs
+}
+
+type subschema struct {
+	location string
+	schema   *Schema
+}
+
+// subschemas lists the schemas nested in s, with their JSON pointer.
+func (s *Schema) subschemas(location string) []subschema {
+	var subs []subschema
+	for _, keyword := range []struct {
+		name    string
+		schemas map[string]*Schema
+	}{{"$defs", s.Defs}, {"definitions", s.Definitions}, {"properties", s.Properties}, {"patternProperties", s.PatternProperties}} {
+		for name, schema := range keyword.schemas {
+			subs = append(subs, subschema{location + "/" + keyword.name + "/" + escapePointer(name), schema})
+		}
+	}
+	for _, keyword := range []struct {
+		name    string
+		schemas []*Schema
+	}{{"prefixItems", s.PrefixItems}, {"allOf", s.AllOf}, {"anyOf", s.AnyOf}, {"oneOf", s.OneOf}} {
+		for i, schema := range keyword.schemas {
+			subs = append(subs, subschema{location + "/" + keyword.name + "/" + strconv.Itoa(i), schema})
+		}
+	}
+	for _, keyword := range []struct {
+		name   string
+		schema *Schema
+	}{{"additionalProperties", s.AdditionalProperties}, {"items", s.Items}, {"contains", s.Contains}, {"not", s.Not}, {"if", s.If}, {"then", s.Then}, {"else", s.Else}} {
+		subs = append(subs, subschema{location + "/" + keyword.name, keyword.schema})
+	}
+	return subs
+}
+
+// lookup resolves a reference within the document, such as "#", "#/$defs/reading" or
+// "#/properties/location/properties/lat".
+func (s *Schema) lookup(ref string) (*Schema, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q: only references within the schema, starting with #, are supported", ref)
+	}
+
+	current := s
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	if pointer == "" {
+		tokens = nil
+	}
+	for i := 0; i < len(tokens); i++ {
+		if current == nil || current.boolean != nil {
+			break
+		}
+
+		keyword := unescapePointer(tokens[i])
+		var next *Schema
+		switch keyword {
+		case "$defs", "definitions", "properties", "patternProperties":
+			if i+1 == len(tokens) {
+				return nil, fmt.Errorf("unresolved $ref %q", ref)
+			}
+			i++
+			name := unescapePointer(tokens[i])
+			next = map[string]map[string]*Schema{"$defs": current.Defs, "definitions": current.Definitions, "properties": current.Properties, "patternProperties": current.PatternProperties}[keyword][name]
+		case "prefixItems", "allOf", "anyOf", "oneOf":
+			if i+1 == len(tokens) {
+				return nil, fmt.Errorf("unresolved $ref %q", ref)
+			}
+			i++
+			list := map[string][]*Schema{"prefixItems": current.PrefixItems, "allOf": current.AllOf, "anyOf": current.AnyOf, "oneOf": current.OneOf}[keyword]
+			if index, err := strconv.Atoi(tokens[i]); err == nil && index >= 0 && index < len(list) {
+				next = list[index]
+			}
+		default:
+			next = map[string]*Schema{"additionalProperties": current.AdditionalProperties, "items": current.Items, "contains": current.Contains, "not": current.Not, "if": current.If, "then": current.Then, "else": current.Else}[keyword]
+		}
+		current = next
+	}
+
+	if current == nil {
+		return nil, fmt.Errorf("unresolved $ref %q", ref)
+	}
+	return current, nil
+}
+
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func unescapePointer(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
@@ -0,0 +1,417 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError is a constraint a value breaks, at a path such as $.sensors[0].temp.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks a decoded JSON value, and returns the constraints it breaks, or none when it is valid.
+func (s *Schema) Validate(value interface{}) []ValidationError {
+	errs := []ValidationError{}
+	s.validate(value, "$", &errs)
+	return errs
+}
+
+// ValidateJSON decodes data and validates it.
+func (s *Schema) ValidateJSON(data []byte) []ValidationError {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []ValidationError{{Path: "$", Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	return s.Validate(value)
+}
+
+func (s *Schema) valid(value interface{}) bool {
+	var errs []ValidationError
+	s.validate(value, "$", &errs)
+	return len(errs) == 0
+}
+
+func (s *Schema) validate(value interface{}, path string, errs *[]ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.boolean != nil {
+		if !*s.boolean {
+			fail("no value is allowed here")
+		}
+		return
+	}
+
+	if s.ref != nil {
+		s.ref.validate(value, path, errs)
+	}
+
+	kind := typeOf(value)
+	if len(s.Type) > 0 && !s.Type.allows(kind, value) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), kind)
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if equal(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", literals(s.Enum))
+		}
+	}
+	if s.HasConst && !equal(value, s.Const) {
+		fail("must be %s", literal(s.Const))
+	}
+
+	switch kind {
+	case "object":
+		s.validateObject(value.(map[string]interface{}), path, errs)
+	case "array":
+		s.validateArray(value.([]interface{}), path, errs)
+	case "number", "integer":
+		n, _ := number(value)
+		s.validateNumber(n, fail)
+	case "string":
+		s.validateString(value.(string), fail)
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(value, path, errs)
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.valid(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any schema of anyOf")
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if sub.valid(value) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("matches %d schemas of oneOf, expected exactly one", matched)
+		}
+	}
+	if s.Not != nil && s.Not.valid(value) {
+		fail("must not match the schema of not")
+	}
+	if s.If != nil {
+		if s.If.valid(value) {
+			if s.Then != nil {
+				s.Then.validate(value, path, errs)
+			}
+		} else if s.Else != nil {
+			s.Else.validate(value, path, errs)
+		}
+	}
+}
+
+func (s *Schema) validateObject(object map[string]interface{}, path string, errs *[]ValidationError) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			*errs = append(*errs, ValidationError{Path: propertyPath(path, name), Message: "is required"})
+		}
+	}
+
+	if s.MinProperties != nil && len(object) < *s.MinProperties {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at least %d properties, got %d", *s.MinProperties, len(object))})
+	}
+	if s.MaxProperties != nil && len(object) > *s.MaxProperties {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at most %d properties, got %d", *s.MaxProperties, len(object))})
+	}
+
+	// Sorted so that errors come in a stable order
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, at := object[name], propertyPath(path, name)
+		matched := false
+		if sub, ok := s.Properties[name]; ok {
+			sub.validate(value, at, errs)
+			matched = true
+		}
+		for pattern, re := range s.patterns {
+			if re.MatchString(name) {
+				s.PatternProperties[pattern].validate(value, at, errs)
+				matched = true
+			}
+		}
+		if !matched && s.AdditionalProperties != nil {
+			if s.AdditionalProperties.boolean != nil && !*s.AdditionalProperties.boolean {
+				*errs = append(*errs, ValidationError{Path: at, Message: "is not an allowed property"})
+			} else {
+				s.AdditionalProperties.validate(value, at, errs)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateArray(array []interface{}, path string, errs *[]ValidationError) {
+	if s.MinItems != nil && len(array) < *s.MinItems {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at least %d items, got %d", *s.MinItems, len(array))})
+	}
+	if s.MaxItems != nil && len(array) > *s.MaxItems {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("must have at most %d items, got %d", *s.MaxItems, len(array))})
+	}
+
+	for i, item := range array {
+		at := path + "[" + strconv.Itoa(i) + "]"
+		if i < len(s.PrefixItems) {
+			s.PrefixItems[i].validate(item, at, errs)
+		} else if s.Items != nil {
+			s.Items.validate(item, at, errs)
+		}
+	}
+
+	if s.UniqueItems {
+	unique:
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if equal(array[i], array[j]) {
+					*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("items %d and %d are equal, items must be unique", i, j)})
+					break unique
+				}
+			}
+		}
+	}
+
+	if s.Contains != nil {
+		found := false
+		for _, item := range array {
+			if s.Contains.valid(item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*errs = append(*errs, ValidationError{Path: path, Message: "no item matches the schema of contains"})
+		}
+	}
+}
+
+func (s *Schema) validateNumber(n float64, fail func(string, ...interface{})) {
+	if s.Minimum != nil && n < *s.Minimum {
+		fail("must be >= %v, got %v", *s.Minimum, n)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		fail("must be <= %v, got %v", *s.Maximum, n)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		fail("must be > %v, got %v", *s.ExclusiveMinimum, n)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		fail("must be < %v, got %v", *s.ExclusiveMaximum, n)
+	}
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		q := n / *s.MultipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v, got %v", *s.MultipleOf, n)
+		}
+	}
+}
+
+func (s *Schema) validateString(str string, fail func(string, ...interface{})) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		fail("must be at least %d characters long, got %d", *s.MinLength, length)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		fail("must be at most %d characters long, got %d", *s.MaxLength, length)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		fail("must match the pattern %s", s.Pattern)
+	}
+	if s.Format != "" && !validFormat(s.Format, str) {
+		fail("must be a valid %s", s.Format)
+	}
+}
+
+var (
+	hostnamePattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+	uuidPattern     = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// validFormat checks the formats it knows, and accepts any string for the others, as formats are annotations
+// unless a validator supports them.
+func validFormat(format, str string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, str)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, str)
+		return err == nil
+	case "time":
+		_, err := time.Parse("15:04:05.999999999Z07:00", str)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(str)
+		return err == nil && addr.Address == str
+	case "hostname":
+		return len(str) <= 253 && hostnamePattern.MatchString(str)
+	case "ipv4":
+		ip := net.ParseIP(str)
+		return ip != nil && ip.To4() != nil && !strings.Contains(str, ":")
+	case "ipv6":
+		return net.ParseIP(str) != nil && strings.Contains(str, ":")
+	case "uri":
+		u, err := url.Parse(str)
+		return err == nil && u.Scheme != ""
+	case "uuid":
+		return uuidPattern.MatchString(str)
+	default:
+		return true
+	}
+}
+
+// typeOf returns the JSON type of a decoded value. Numbers are "integer" when they have no fractional part.
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		if n, ok := number(v); ok {
+			if n == math.Trunc(n) && !math.IsInf(n, 0) {
+				return "integer"
+			}
+			return "number"
+		}
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func (t Types) allows(kind string, value interface{}) bool {
+	for _, name := range t {
+		if name == kind || (name == "number" && kind == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// number reads the numbers of decoded JSON, and those set by Go code such as a fresh timestamp.
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// equal compares decoded JSON values, numbers by value.
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// propertyPath appends a property to a path, in the bracket notation when it is not an identifier.
+func propertyPath(path, name string) string {
+	if identifier.MatchString(name) {
+		return path + "." + name
+	}
+	return path + "['" + strings.ReplaceAll(name, "'", `\'`) + "']"
+}
+
+func literal(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func literals(values []interface{}) string {
+	texts := make([]string, len(values))
+	for i, value := range values {
+		texts[i] = literal(value)
+	}
+	return strings.Join(texts, ", ")
+}
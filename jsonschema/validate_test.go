@@ -0,0 +1,148 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, schema string) *Schema {
+	t.Helper()
+	s, err := Compile([]byte(schema))
+	if err != nil {
+		t.Fatalf("Compile(%s): %v", schema, err)
+	}
+	return s
+}
+
+func validate(t *testing.T, s *Schema, doc string) []ValidationError {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		t.Fatalf("invalid test document %s: %v", doc, err)
+	}
+	return s.Validate(value)
+}
+
+type validateCase struct {
+	doc   string
+	valid bool
+}
+
+func checkCases(t *testing.T, schema string, cases []validateCase) {
+	t.Helper()
+	s := compile(t, schema)
+	for _, c := range cases {
+		errs := validate(t, s, c.doc)
+		if valid := len(errs) == 0; valid != c.valid {
+			t.Errorf("schema %s, document %s: valid = %v, want %v (errors: %v)", schema, c.doc, valid, c.valid, errs)
+		}
+	}
+}
+
+func TestRefs(t *testing.T) {
+	checkCases(t, `{
+		"$defs": {"reading": {"type": "object", "properties": {"temp": {"type": "number"}}, "required": ["temp"]}},
+		"type": "object",
+		"properties": {"current": {"$ref": "#/$defs/reading"}, "last": {"$ref": "#/properties/current"}}
+	}`, []validateCase{
+		{`{}`, true},
+		{`{"current": {"temp": 21.5}, "last": {"temp": 20}}`, true},
+		{`{"current": {}}`, false},
+		{`{"last": {"temp": "hot"}}`, false},
+	})
+
+	checkCases(t, `{"definitions": {"a~b/c": {"const": 1}}, "$ref": "#/definitions/a~0b~1c"}`, []validateCase{
+		{`1`, true},
+		{`2`, false},
+	})
+
+	// Recursion through a property or an item goes deeper into the value, and stops with it
+	checkCases(t, `{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "children": {"type": "array", "items": {"$ref": "#"}}},
+		"required": ["name"]
+	}`, []validateCase{
+		{`{"name": "a", "children": [{"name": "b", "children": [{"name": "c"}]}]}`, true},
+		{`{"name": "a", "children": [{"name": "b", "children": [{}]}]}`, false},
+	})
+}
+
+func TestRefErrors(t *testing.T) {
+	for _, schema := range []string{
+		`{"$ref": "#/$defs/missing"}`,
+		`{"$ref": "other.json#/$defs/a"}`,
+		`{"$ref": "#"}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"allOf": [{"$ref": "#/$defs/a"}]}}}`,
+		`{"anyOf": [{"not": {"$ref": "#"}}]}`,
+		`{"properties": {"x": {"if": {"$ref": "#/properties/x"}}}}`,
+	} {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error", schema)
+		}
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	checkCases(t, `{"allOf": [{"type": "integer"}, {"minimum": 3}]}`, []validateCase{
+		{`3`, true},
+		{`2`, false},
+		{`3.5`, false},
+	})
+	checkCases(t, `{"anyOf": [{"type": "string"}, {"type": "null"}]}`, []validateCase{
+		{`"a"`, true},
+		{`null`, true},
+		{`1`, false},
+	})
+	checkCases(t, `{"oneOf": [{"type": "integer"}, {"type": "number", "minimum": 10}]}`, []validateCase{
+		{`1`, true},
+		{`10.5`, true},
+		{`11`, false},
+	})
+	checkCases(t, `{"not": {"type": "string"}}`, []validateCase{
+		{`1`, true},
+		{`"a"`, false},
+	})
+	checkCases(t, `{
+		"if": {"properties": {"unit": {"const": "C"}}},
+		"then": {"properties": {"temp": {"maximum": 100}}},
+		"else": {"properties": {"temp": {"maximum": 212}}}
+	}`, []validateCase{
+		{`{"unit": "C", "temp": 90}`, true},
+		{`{"unit": "C", "temp": 150}`, false},
+		{`{"unit": "F", "temp": 150}`, true},
+		{`{"unit": "F", "temp": 250}`, false},
+	})
+}
+
+func TestFormats(t *testing.T) {
+	for format, cases := range map[string][]validateCase{
+		"date-time": {{`"2024-05-01T12:30:00Z"`, true}, {`"2024-05-01T12:30:00.5+02:00"`, true}, {`"2024-05-01 12:30"`, false}},
+		"date":      {{`"2024-05-01"`, true}, {`"2024-13-01"`, false}},
+		"time":      {{`"12:30:00Z"`, true}, {`"25:00:00Z"`, false}},
+		"email":     {{`"ops@example.com"`, true}, {`"ops"`, false}},
+		"hostname":  {{`"broker.example.com"`, true}, {`"-bad-.com"`, false}},
+		"ipv4":      {{`"192.168.1.10"`, true}, {`"256.1.1.1"`, false}, {`"::1"`, false}},
+		"ipv6":      {{`"::1"`, true}, {`"192.168.1.10"`, false}},
+		"uri":       {{`"mqtt://broker:1883/a"`, true}, {`"/relative"`, false}},
+		"uuid":      {{`"123e4567-e89b-12d3-a456-426614174000"`, true}, {`"123e4567"`, false}},
+	} {
+		checkCases(t, `{"format": "`+format+`"}`, cases)
+	}
+
+	// Formats apply to strings only, and unknown formats are ignored
+	checkCases(t, `{"format": "ipv4"}`, []validateCase{{`42`, true}})
+	checkCases(t, `{"format": "color"}`, []validateCase{{`"red"`, true}})
+}
+
+func TestErrorPaths(t *testing.T) {
+	s := compile(t, `{"properties": {"sensors": {"items": {"properties": {"temp": {"type": "number"}}}}}}`)
+	errs := validate(t, s, `{"sensors": [{"temp": 1}, {"temp": "x"}]}`)
+	if len(errs) != 1 || errs[0].Path != "$.sensors[1].temp" {
+		t.Fatalf("got %v, want one error at $.sensors[1].temp", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "expected number") {
+		t.Errorf("unexpected message %q", errs[0].Error())
+	}
+}
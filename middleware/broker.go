@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// PostBrokerWipe drops the broker sessions, subscriptions, retained and inflight messages, stored ones included.
+func PostBrokerWipe(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	report, err := ar.Broker.Wipe()
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to wipe broker state: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, report)
+}
+
+// PostAdminReload reads the configuration files again and applies what can change without a restart.
+func PostAdminReload(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Reloader == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Configuration reload not available")
+		return
+	}
+
+	report := ar.Reloader.Reload("api")
+	if len(report.Errors) > 0 {
+		Respond_With_JSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, report)
+}
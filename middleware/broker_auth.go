@@ -0,0 +1,410 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+
+	"mqtt-mochi-server/broker"
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+type brokerUserRequest struct {
+	Position int     `json:"position"`
+	Client   string  `json:"client"`
+	Username string  `json:"username"`
+	Remote   string  `json:"remote"`
+	Password *string `json:"password"`
+	Allow    *bool   `json:"allow"`
+}
+
+func (req brokerUserRequest) toUser() db.BrokerUser {
+	user := db.BrokerUser{Position: req.Position, Client: req.Client, Username: req.Username, Remote: req.Remote, Allow: true}
+	if req.Allow != nil {
+		user.Allow = *req.Allow
+	}
+	return user
+}
+
+type brokerACLRuleRequest struct {
+	Position int            `json:"position"`
+	Client   string         `json:"client"`
+	Username string         `json:"username"`
+	Remote   string         `json:"remote"`
+	Filters  map[string]int `json:"filters"`
+}
+
+func (req brokerACLRuleRequest) toRule() (db.BrokerACLRule, error) {
+	for filter, access := range req.Filters {
+		if err := mqtttopic.ValidateFilter(filter); err != nil {
+			return db.BrokerACLRule{}, fmt.Errorf("invalid filter %q: %v", filter, err)
+		}
+		if access < int(auth.Deny) || access > int(auth.ReadWrite) {
+			return db.BrokerACLRule{}, fmt.Errorf("invalid access %d for filter %q, expected 0 (deny), 1 (read), 2 (write) or 3 (read and write)", access, filter)
+		}
+	}
+
+	filters := req.Filters
+	if filters == nil {
+		filters = map[string]int{}
+	}
+
+	return db.BrokerACLRule{Position: req.Position, Client: req.Client, Username: req.Username, Remote: req.Remote, Filters: filters}, nil
+}
+
+// brokerAuthManaged refuses ledger edits when the broker allows all clients, as they would be stored without
+// taking effect.
+func brokerAuthManaged(w http.ResponseWriter, ar *AppRouter) bool {
+	if ar.Broker == nil || ar.Broker.Auth == nil {
+		Respond_With_JSON(w, http.StatusConflict, "The broker allows all clients, so auth rules would take no effect. Configure hooks.auth without allow_all and restart to manage them")
+		return false
+	}
+	return true
+}
+
+// reloadBrokerAuth makes ledger edits visible to the broker for the next connections.
+func reloadBrokerAuth(w http.ResponseWriter, ar *AppRouter) bool {
+	if ar.Broker == nil || ar.Broker.Auth == nil {
+		return true
+	}
+
+	if err := ar.Broker.Auth.Reload(); err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Saved, but failed to reload the broker ledger: %v", err))
+		return false
+	}
+
+	return true
+}
+
+func GetBrokerUsers(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	users, err := db.ListBrokerUsers(ar.DB)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve broker users: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, users)
+}
+
+func GetBrokerUserByID(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := db.GetBrokerUser(ar.DB, id)
+	if errors.Is(err, db.ErrBrokerUserNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Broker user with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve broker user: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, user)
+}
+
+func PostBrokerUser(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	var req brokerUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	password := ""
+	if req.Password != nil {
+		password = *req.Password
+	}
+
+	user, err := db.CreateBrokerUser(ar.DB, req.toUser(), password)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create broker user: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, user)
+}
+
+func PutBrokerUser(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req brokerUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := db.UpdateBrokerUser(ar.DB, id, req.toUser(), req.Password)
+	if errors.Is(err, db.ErrBrokerUserNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Broker user with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update broker user: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, user)
+}
+
+func DeleteBrokerUser(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := db.DeleteBrokerUser(ar.DB, id)
+	if errors.Is(err, db.ErrBrokerUserNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Broker user with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete broker user: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, fmt.Sprintf("Broker user with ID %d deleted successfully", id))
+}
+
+func GetBrokerACL(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	rules, err := db.ListBrokerACL(ar.DB)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve broker ACL: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, rules)
+}
+
+func PostBrokerACLRule(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	var req brokerACLRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	rule, err := req.toRule()
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule, err = db.CreateBrokerACLRule(ar.DB, rule)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create broker ACL rule: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, rule)
+}
+
+func PutBrokerACLRule(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req brokerACLRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	rule, err := req.toRule()
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule, err = db.UpdateBrokerACLRule(ar.DB, id, rule)
+	if errors.Is(err, db.ErrBrokerACLRuleNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Broker ACL rule with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update broker ACL rule: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, rule)
+}
+
+func DeleteBrokerACLRule(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := db.DeleteBrokerACLRule(ar.DB, id)
+	if errors.Is(err, db.ErrBrokerACLRuleNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Broker ACL rule with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete broker ACL rule: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, fmt.Sprintf("Broker ACL rule with ID %d deleted successfully", id))
+}
+
+// PostBrokerLedger imports a mochi-style ledger, in JSON or YAML. With mode=replace the stored ledger is
+// dropped first, otherwise the imported rules are evaluated after the existing ones.
+func PostBrokerLedger(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+	if !brokerAuthManaged(w, ar) {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = db.ImportModeMerge
+	}
+	if mode != db.ImportModeMerge && mode != db.ImportModeReplace {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'mode' parameter %q, expected merge or replace", mode))
+		return
+	}
+
+	defer r.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize))
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+
+	ledger := new(auth.Ledger)
+	if err := ledger.Unmarshal(data); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid ledger: %v", err))
+		return
+	}
+
+	users, acl, err := broker.LedgerRules(ledger)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid ledger: %v", err))
+		return
+	}
+
+	if err := db.ImportBrokerLedger(ar.DB, users, acl, mode == db.ImportModeReplace); err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to import ledger: %v", err))
+		return
+	}
+
+	if !reloadBrokerAuth(w, ar) {
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, map[string]int{"users": len(users), "acl": len(acl)})
+}
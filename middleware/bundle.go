@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+// Bundles larger than this are rejected on import
+const maxBundleSize = 32 << 20
+
+// GetExport downloads the simulation as a bundle. The body is the bundle itself, not wrapped in the usual
+// JSON result, so that it can be fed back to POST /import as is.
+func GetExport(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	var ids []int
+	if idsParam := r.URL.Query().Get("ids"); idsParam != "" {
+		for _, idStr := range strings.Split(idsParam, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid id %q in 'ids' parameter", idStr))
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = db.BundleFormatJSON
+	}
+
+	bundle, err := db.Export(ar.DB, ids)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to export messages: %v", err))
+		return
+	}
+
+	body, err := db.EncodeBundle(bundle, format)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Failed to encode bundle: %v", err))
+		return
+	}
+
+	contentType := "application/json; charset=UTF-8"
+	if format != db.BundleFormatJSON {
+		contentType = "application/yaml; charset=UTF-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"simulation.%s\"", format))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Failed to write the response body: %v", err)
+	}
+}
+
+// PostImport loads a bundle sent as the request body. The format is taken from the 'format' parameter,
+// then from the Content-Type header, and detected from the body otherwise.
+func PostImport(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	params := r.URL.Query()
+	opts := db.ImportOptions{
+		Mode:       params.Get("mode"),
+		OnConflict: params.Get("on_conflict"),
+		Author:     requestAuthor(r),
+	}
+	if opts.Mode == "" {
+		opts.Mode = db.ImportModeMerge
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = db.ConflictSkip
+	}
+
+	if dryRun := params.Get("dry_run"); dryRun != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'dry_run' parameter")
+			return
+		}
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		contentType := r.Header.Get("Content-Type")
+		if strings.Contains(contentType, "yaml") {
+			format = db.BundleFormatYAML
+		} else if strings.Contains(contentType, "json") {
+			format = db.BundleFormatJSON
+		}
+	}
+
+	defer r.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+	if len(data) > maxBundleSize {
+		Respond_With_JSON(w, http.StatusRequestEntityTooLarge, "Bundle is too large")
+		return
+	}
+
+	bundle, err := db.DecodeBundle(data, format)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid bundle: %v", err))
+		return
+	}
+	for _, msg := range bundle.Messages {
+		if err := mqtttopic.ValidateTopic(msg.Topic); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid bundle: message %d: invalid topic %q: %v", msg.ID, msg.Topic, err))
+			return
+		}
+	}
+
+	report, err := db.Import(ar.DB, bundle, opts)
+	if errors.Is(err, db.ErrUnsupportedBundle) || errors.Is(err, db.ErrInvalidImportOptions) {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to import bundle: %v", err))
+		return
+	}
+
+	if !report.DryRun {
+		ar.RestartChan <- struct{}{}
+	}
+
+	Respond_With_JSON(w, http.StatusOK, report)
+}
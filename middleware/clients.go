@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"mqtt-mochi-server/broker"
+)
+
+// GetBrokerClients lists the clients of the embedded broker, with the sessions kept for disconnected ones.
+func GetBrokerClients(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, ar.Broker.Clients())
+}
+
+func GetBrokerClientByID(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	client, err := ar.Broker.Client(mux.Vars(r)["id"])
+	if errors.Is(err, broker.ErrClientNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, client)
+}
+
+// DeleteBrokerClient disconnects a client. The `reason` parameter is the reason code sent to v5 clients,
+// e.g. 0x98 (administrative action, the default) or 152.
+func DeleteBrokerClient(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	code := packets.ErrAdministrativeAction
+	if reason := r.URL.Query().Get("reason"); reason != "" {
+		value, err := strconv.ParseUint(reason, 0, 8)
+		if err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'reason' parameter %q, expected a reason code such as 0x98", reason))
+			return
+		}
+		if code, err = broker.DisconnectCode(byte(value)); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'reason' parameter: %v", err))
+			return
+		}
+	}
+
+	client, err := ar.Broker.Disconnect(mux.Vars(r)["id"], code)
+	if errors.Is(err, broker.ErrClientNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, err.Error())
+		return
+	} else if errors.Is(err, broker.ErrClientNotConnected) {
+		Respond_With_JSON(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, client)
+}
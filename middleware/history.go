@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mqtt-mochi-server/db"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+type historyPage struct {
+	Entries    []db.PublishLogEntry `json:"entries"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// GetHistory lists what the simulator published, oldest first. Pass the returned next_cursor back as
+// `cursor` to fetch the following page.
+func GetHistory(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	params := r.URL.Query()
+	query := db.PublishLogQuery{
+		Topic: params.Get("topic"),
+		Limit: defaultHistoryLimit,
+	}
+
+	var err error
+	if from := params.Get("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'from' parameter, expected an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	if to := params.Get("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'to' parameter, expected an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxHistoryLimit {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'limit' parameter, expected a number between 1 and %d", maxHistoryLimit))
+			return
+		}
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		query.After, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || query.After < 0 {
+			Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'cursor' parameter")
+			return
+		}
+	}
+
+	entries, err := db.QueryPublishLog(ar.DB, query)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to query history: %v", err))
+		return
+	}
+
+	page := historyPage{Entries: entries}
+	if len(entries) == query.Limit {
+		page.NextCursor = strconv.FormatInt(entries[len(entries)-1].ID, 10)
+	}
+
+	Respond_With_JSON(w, http.StatusOK, page)
+}
@@ -1,13 +1,24 @@
 package middleware
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+const (
+	defaultMessagesLimit = 100
+	maxMessagesLimit     = 1000
 )
 
 type Message struct {
@@ -38,15 +49,18 @@ func PostMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	payloadBytes, err := json.Marshal(msg.Payload)
-	if err != nil {
-		Respond_With_JSON(w, http.StatusInternalServerError, "Failed to marshal payload")
+	if err := mqtttopic.ValidateTopic(msg.Topic); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid topic %q: %v", msg.Topic, err))
+		return
+	}
+	if !validatePayload(w, ar, nil, msg.Topic, msg.Payload) {
 		return
 	}
 
-	_, err = ar.DB.Exec("INSERT INTO messages (topic, payload, frequency) VALUES ($1, $2, $3)", msg.Topic, payloadBytes, msg.Frequency)
+	_, err := db.CreateMessage(ar.DB, db.MessageSnapshot{Topic: msg.Topic, Payload: msg.Payload, Frequency: msg.Frequency}, requestAuthor(r))
 	if err != nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to insert message into database: %v", err))
+		return
 	}
 
 	// Notify the publisher manager to restart
@@ -55,45 +69,96 @@ func PostMessage(w http.ResponseWriter, r *http.Request) {
 	Respond_With_JSON(w, http.StatusOK, "Message added successfully")
 }
 
+// GetMessages lists the messages a page at a time, filtered by `topic` (an MQTT filter), `q` (a full-text
+// search of the payloads) and `status` (running or stopped), and sorted by `sort`. Pass the returned
+// next_cursor back as `cursor` to fetch the following page.
 func GetMessages(w http.ResponseWriter, r *http.Request) {
 	ar, ok := r.Context().Value("appRouter").(*AppRouter)
 	if !ok || ar == nil || ar.DB == nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
 	}
 
-	rows, err := ar.DB.Query("SELECT topic, payload, frequency FROM messages")
-	if err != nil {
-		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to query messages: %v", err))
+	params := r.URL.Query()
+	query := db.MessageQuery{
+		TopicFilter: params.Get("topic"),
+		Search:      params.Get("q"),
+		Cursor:      params.Get("cursor"),
+		Limit:       defaultMessagesLimit,
+	}
+
+	// Messages have no project nor tags yet; refusing these filters beats silently ignoring them
+	for _, name := range []string{"project", "tag"} {
+		if params.Has(name) {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Filtering on '%s' is not supported, messages have no %s", name, name))
+			return
+		}
 	}
-	defer rows.Close()
 
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var payloadBytes []byte
-		if err := rows.Scan(&msg.Topic, &payloadBytes, &msg.Frequency); err != nil {
-			Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to scan row: %v", err))
+	if query.TopicFilter != "" {
+		if err := mqtttopic.ValidateFilter(query.TopicFilter); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'topic' parameter: %v", err))
+			return
 		}
+	}
 
-		// Unmarshal the JSONB payload back into the interface{}
-		if err := json.Unmarshal(payloadBytes, &msg.Payload); err != nil {
-			Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to unmarshal payload: %v", err))
+	switch status := params.Get("status"); status {
+	case "":
+	case "running", "stopped":
+		running := []int{}
+		if ar.Publisher != nil {
+			for _, s := range ar.Publisher.Status() {
+				if s.Running {
+					running = append(running, s.MessageID)
+				}
+			}
+		}
+		if status == "running" {
+			query.IDs = running
+		} else {
+			query.ExceptIDs = running
 		}
+	default:
+		Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'status' parameter, expected running or stopped")
+		return
+	}
 
-		messages = append(messages, msg)
+	if sort := params.Get("sort"); sort != "" {
+		query.Desc = strings.HasPrefix(sort, "-")
+		query.Sort = strings.TrimPrefix(sort, "-")
+		if query.Sort != db.MessageSortID && query.Sort != db.MessageSortTopic && query.Sort != db.MessageSortFrequency {
+			Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'sort' parameter, expected id, topic or frequency, prefixed with '-' for descending order")
+			return
+		}
 	}
 
-	if err := rows.Err(); err != nil {
-		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Rows iteration error: %v", err))
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxMessagesLimit {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'limit' parameter, expected a number between 1 and %d", maxMessagesLimit))
+			return
+		}
+	}
+
+	page, err := db.QueryMessages(ar.DB, query)
+	if errors.Is(err, db.ErrInvalidMessageCursor) {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'cursor' parameter: %v", err))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to query messages: %v", err))
+		return
 	}
 
-	Respond_With_JSON(w, http.StatusOK, messages)
+	Respond_With_JSON(w, http.StatusOK, page)
 }
 
 func GetMessageByID(w http.ResponseWriter, r *http.Request) {
 	ar, ok := r.Context().Value("appRouter").(*AppRouter)
 	if !ok || ar == nil || ar.DB == nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
 	}
 
 	vars := mux.Vars(r)
@@ -111,7 +176,11 @@ func GetMessageByID(w http.ResponseWriter, r *http.Request) {
 
 	var msg Message
 	var payloadBytes []byte
-	err = ar.DB.QueryRow("SELECT id, topic, payload, frequency FROM messages WHERE id = $1", id).Scan(&msg.ID, &msg.Topic, &payloadBytes, &msg.Frequency)
+	err = ar.DB.QueryRow("SELECT id, topic, payload, frequency FROM messages WHERE id = $1 AND deleted_at IS NULL", id).Scan(&msg.ID, &msg.Topic, &payloadBytes, &msg.Frequency)
+	if errors.Is(err, sql.ErrNoRows) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Message with ID %d not found", id))
+		return
+	}
 	if err != nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve message: %v", err))
 		return
@@ -128,36 +197,51 @@ func PutMessage(w http.ResponseWriter, r *http.Request) {
 	ar, ok := r.Context().Value("appRouter").(*AppRouter)
 	if !ok || ar == nil || ar.DB == nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
 	}
 
 	vars := mux.Vars(r)
 	idStr, ok := vars["id"]
 	if !ok {
 		Respond_With_JSON(w, http.StatusBadRequest, "Missing 'id' parameter")
+		return
 	}
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'id' parameter")
+		return
 	}
 
 	var msg Message
 
 	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
 		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
 	}
 	defer r.Body.Close()
 
-	payloadBytes, err := json.Marshal(msg.Payload)
-	if err != nil {
-		Respond_With_JSON(w, http.StatusInternalServerError, "Failed to marshal payload")
+	if err := mqtttopic.ValidateTopic(msg.Topic); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid topic %q: %v", msg.Topic, err))
+		return
+	}
+	if !validatePayload(w, ar, &id, msg.Topic, msg.Payload) {
+		return
 	}
 
-	_, err = ar.DB.Exec("UPDATE messages SET topic = $1, payload = $2, frequency = $3 WHERE id = $4", msg.Topic, payloadBytes, msg.Frequency, id)
+	err = db.UpdateMessage(ar.DB, id, db.MessageSnapshot{Topic: msg.Topic, Payload: msg.Payload, Frequency: msg.Frequency}, requestAuthor(r))
+	if errors.Is(err, db.ErrMessageNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Message with ID %d not found", id))
+		return
+	}
 	if err != nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update message: %v", err))
+		return
 	}
 
+	ar.RestartChan <- struct{}{}
+
+	msg.ID = id
 	Respond_With_JSON(w, http.StatusOK, msg)
 }
 
@@ -165,24 +249,34 @@ func DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	ar, ok := r.Context().Value("appRouter").(*AppRouter)
 	if !ok || ar == nil || ar.DB == nil {
 		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
 	}
 
 	vars := mux.Vars(r)
 	idStr, ok := vars["id"]
 	if !ok {
 		Respond_With_JSON(w, http.StatusBadRequest, "Missing 'id' parameter")
+		return
 	}
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'id' parameter")
+		return
 	}
 
-	_, err = ar.DB.Exec("DELETE FROM messages WHERE id = $1", id)
+	err = db.DeleteMessage(ar.DB, id, requestAuthor(r))
+	if errors.Is(err, db.ErrMessageNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Message with ID %d not found", id))
+		return
+	}
 	if err != nil {
-		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update message: %v", err))
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete message: %v", err))
+		return
 	}
 
+	ar.RestartChan <- struct{}{}
+
 	Respond_With_JSON(w, http.StatusOK, fmt.Sprintf("Message with ID %d deleted successfully", id))
 }
 
@@ -6,6 +6,11 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+
+	"mqtt-mochi-server/broker"
+	"mqtt-mochi-server/publisher"
+	"mqtt-mochi-server/reload"
+	"mqtt-mochi-server/ws"
 )
 
 // AppRouterInjector is a middleware that injects the AppRouter into the request context.
@@ -22,4 +27,8 @@ type AppRouter struct {
 	Router      *mux.Router
 	DB          *sql.DB
 	RestartChan chan struct{}
+	Broker      *broker.Broker
+	Reloader    *reload.Reloader
+	Publisher   *publisher.Manager
+	WSHub       *ws.Hub
 }
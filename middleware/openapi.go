@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"mqtt-mochi-server/openapi"
+)
+
+const (
+	maxValidatedRequest  = 32 << 20
+	maxValidatedResponse = 1 << 20
+)
+
+// GetOpenAPI serves the OpenAPI document of the API as is, without the result envelope.
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openapi.Document())
+}
+
+// ValidateAPI checks request and response bodies against the OpenAPI document. A JSON request body that does
+// not match its operation is rejected with 400 and the list of violations. Responses are only logged when they
+// don't match, since the client already got them. prefix is where the paths of the document are mounted.
+func ValidateAPI(spec *openapi.Spec, prefix string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			template, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			path := strings.TrimPrefix(template, prefix)
+
+			op := spec.Route(r.Method, path)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if op.RequestBody != nil && isJSON(r.Header.Get("Content-Type"), true) {
+				body, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedRequest+1))
+				if err != nil {
+					Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+					return
+				}
+				// Larger bodies go to the handler unchecked, and in full
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+				// Bodies that are not JSON are left to the handler, which reports them as it always has
+				var value interface{}
+				if len(body) <= maxValidatedRequest && len(bytes.TrimSpace(body)) > 0 && json.Unmarshal(body, &value) == nil {
+					if violations := op.RequestBody.Validate(value); len(violations) > 0 {
+						respond_With_JSON(w, http.StatusBadRequest, violations, "Request body does not match the API specification")
+						return
+					}
+				}
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			schema := op.Response(recorder.status)
+			if schema == nil || recorder.overflow || recorder.body.Len() == 0 || !isJSON(w.Header().Get("Content-Type"), false) {
+				return
+			}
+			var value interface{}
+			if err := json.Unmarshal(recorder.body.Bytes(), &value); err != nil {
+				log.Printf("%s %s: response is not valid JSON: %v", r.Method, path, err)
+				return
+			}
+			if violations := schema.Validate(value); len(violations) > 0 {
+				log.Printf("%s %s: %d response does not match the API specification: %v", r.Method, path, recorder.status, violations)
+			}
+		})
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isJSON reports whether a Content-Type is JSON, or missing when empty counts as JSON.
+func isJSON(contentType string, empty bool) bool {
+	if contentType == "" {
+		return empty
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// responseRecorder keeps a copy of the start of a response, for it to be validated once sent. Streams are
+// flushed through untouched.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	rec.wroteHeader = true
+	if !rec.overflow {
+		if rec.body.Len()+len(data) > maxValidatedResponse {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(data)
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+func (rec *responseRecorder) Flush() {
+	// Flushed responses are streamed, and not validated
+	rec.overflow = true
+	rec.body.Reset()
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
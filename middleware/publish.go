@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"mqtt-mochi-server/publisher"
+)
+
+// PostPublish publishes an ad-hoc message once, without storing it, and reports its rendered payload.
+func PostPublish(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Publisher == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Publisher not available")
+		return
+	}
+
+	var req publisher.PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	result, err := ar.Publisher.Publish(req)
+	if errors.Is(err, publisher.ErrInvalidPublish) {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to publish: %v", err))
+		return
+	}
+
+	if result.Error != "" {
+		Respond_With_JSON(w, http.StatusBadGateway, result)
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"mqtt-mochi-server/broker"
+	"mqtt-mochi-server/publisher"
+)
+
+// GetBrokerRetained lists the retained messages whose topic matches `filter`, all of them by default.
+func GetBrokerRetained(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		filter = "#"
+	}
+
+	messages, err := ar.Broker.Retained(filter)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'filter' parameter: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, messages)
+}
+
+func GetBrokerRetainedByTopic(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	msg, err := ar.Broker.RetainedMessage(mux.Vars(r)["topic"])
+	if errors.Is(err, broker.ErrRetainedNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, msg)
+}
+
+// PutBrokerRetained sets the message retained on a topic. The body is an ad-hoc publish request without
+// topic, retain or broker, e.g. {"payload": {"state": "on"}}.
+func PutBrokerRetained(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil || ar.Publisher == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	var req publisher.PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Payload == nil || req.Payload == "" {
+		Respond_With_JSON(w, http.StatusBadRequest, "payload is required, use DELETE to clear a retained message")
+		return
+	}
+
+	req.Topic = mux.Vars(r)["topic"]
+	req.Retain = true
+	req.Broker = nil
+
+	result, err := ar.Publisher.Publish(req)
+	if errors.Is(err, publisher.ErrInvalidPublish) {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to publish: %v", err))
+		return
+	}
+
+	if result.Error != "" {
+		Respond_With_JSON(w, http.StatusInternalServerError, result)
+		return
+	}
+
+	msg, err := ar.Broker.RetainedMessage(req.Topic)
+	if err != nil {
+		// The broker does not retain messages, or the payload rendered empty
+		Respond_With_JSON(w, http.StatusConflict, fmt.Sprintf("Message published but not retained: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, msg)
+}
+
+func DeleteBrokerRetainedByTopic(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	topic := mux.Vars(r)["topic"]
+	err := ar.Broker.ClearRetained(topic)
+	if errors.Is(err, broker.ErrRetainedNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, map[string]interface{}{"deleted": 1, "topics": []string{topic}})
+}
+
+// DeleteBrokerRetained clears the retained messages whose topic matches `filter`, which is required so that
+// everything is not wiped by mistake; use filter=# for that.
+func DeleteBrokerRetained(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		Respond_With_JSON(w, http.StatusBadRequest, "Missing 'filter' parameter, use filter=# to clear every retained message")
+		return
+	}
+
+	topics, err := ar.Broker.ClearRetainedMatching(filter)
+	if err != nil && topics == nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'filter' parameter: %v", err))
+		return
+	} else if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, map[string]interface{}{"deleted": len(topics), "topics": topics})
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"mqtt-mochi-server/db"
+)
+
+const anonymousAuthor = "anonymous"
+
+// requestAuthor names who made a change, as sent by the client in the X-Author header.
+func requestAuthor(r *http.Request) string {
+	author := strings.TrimSpace(r.Header.Get("X-Author"))
+	if author == "" {
+		return anonymousAuthor
+	}
+	return author
+}
+
+// idFromRequest reads the integer id path variable, of a message, schema, broker user or ACL rule.
+func idFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		Respond_With_JSON(w, http.StatusBadRequest, "Missing 'id' parameter")
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'id' parameter")
+		return 0, false
+	}
+
+	return id, true
+}
+
+func GetMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := db.ListRevisions(ar.DB, id)
+	if errors.Is(err, db.ErrMessageNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Message with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve revisions: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, revisions)
+}
+
+func RestoreMessageRevision(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	revision, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'rev' parameter")
+		return
+	}
+
+	snapshot, err := db.RestoreRevision(ar.DB, id, revision, requestAuthor(r))
+	if errors.Is(err, db.ErrMessageNotFound) || errors.Is(err, db.ErrRevisionNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Revision %d of message %d not found", revision, id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to restore revision: %v", err))
+		return
+	}
+
+	ar.RestartChan <- struct{}{}
+
+	Respond_With_JSON(w, http.StatusOK, Message{ID: id, Topic: snapshot.Topic, Payload: snapshot.Payload, Frequency: snapshot.Frequency})
+}
+
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	messages, err := db.ListTrash(ar.DB)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve trash: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, messages)
+}
+
+func RestoreTrashedMessage(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	snapshot, err := db.RestoreFromTrash(ar.DB, id, requestAuthor(r))
+	if errors.Is(err, db.ErrMessageNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Message with ID %d is not in the trash", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to restore message: %v", err))
+		return
+	}
+
+	ar.RestartChan <- struct{}{}
+
+	Respond_With_JSON(w, http.StatusOK, Message{ID: id, Topic: snapshot.Topic, Payload: snapshot.Payload, Frequency: snapshot.Frequency})
+}
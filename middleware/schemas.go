@@ -0,0 +1,357 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/jsonschema"
+	"mqtt-mochi-server/mqtttopic"
+	"mqtt-mochi-server/publisher"
+)
+
+type jsonSchemaRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// decodeJSONSchema reads a schema request and checks that the schema compiles.
+func decodeJSONSchema(w http.ResponseWriter, r *http.Request) (db.JSONSchema, bool) {
+	var req jsonSchemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return db.JSONSchema{}, false
+	}
+	defer r.Body.Close()
+
+	if strings.TrimSpace(req.Name) == "" {
+		Respond_With_JSON(w, http.StatusBadRequest, "name is required")
+		return db.JSONSchema{}, false
+	}
+	if len(req.Schema) == 0 {
+		Respond_With_JSON(w, http.StatusBadRequest, "schema is required")
+		return db.JSONSchema{}, false
+	}
+	if _, err := jsonschema.Compile(req.Schema); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return db.JSONSchema{}, false
+	}
+
+	return db.JSONSchema{Name: req.Name, Description: req.Description, Schema: req.Schema}, true
+}
+
+// validatePayload rejects a message payload that breaks the schemas bound to the message or its topic, with
+// the list of violations. messageID is nil for a new message.
+func validatePayload(w http.ResponseWriter, ar *AppRouter, messageID *int, topic string, payload interface{}) bool {
+	if ar.Publisher == nil {
+		return true
+	}
+
+	violations, err := ar.Publisher.ValidateMessage(messageID, topic, payload)
+	if errors.Is(err, publisher.ErrInvalidPayload) {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to validate payload: %v", err))
+		return false
+	}
+	if len(violations) > 0 {
+		respond_With_JSON(w, http.StatusBadRequest, violations, "Payload does not match its JSON schema")
+		return false
+	}
+
+	return true
+}
+
+func GetJSONSchemas(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	schemas, err := db.ListJSONSchemas(ar.DB)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get JSON schemas: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, schemas)
+}
+
+func GetJSONSchemaByID(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	schema, err := db.GetJSONSchema(ar.DB, id)
+	if errors.Is(err, db.ErrJSONSchemaNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("JSON schema with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve JSON schema: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, schema)
+}
+
+func PostJSONSchema(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	schema, ok := decodeJSONSchema(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := db.CreateJSONSchema(ar.DB, schema)
+	if errors.Is(err, db.ErrJSONSchemaNameTaken) {
+		Respond_With_JSON(w, http.StatusConflict, fmt.Sprintf("JSON schema %q already exists", schema.Name))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JSON schema: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, created)
+}
+
+func PutJSONSchema(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	schema, ok := decodeJSONSchema(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := db.UpdateJSONSchema(ar.DB, id, schema)
+	if errors.Is(err, db.ErrJSONSchemaNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("JSON schema with ID %d not found", id))
+		return
+	}
+	if errors.Is(err, db.ErrJSONSchemaNameTaken) {
+		Respond_With_JSON(w, http.StatusConflict, fmt.Sprintf("JSON schema %q already exists", schema.Name))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update JSON schema: %v", err))
+		return
+	}
+
+	// The publishers check payloads against the schemas loaded when they start
+	ar.RestartChan <- struct{}{}
+
+	Respond_With_JSON(w, http.StatusOK, updated)
+}
+
+func DeleteJSONSchema(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := db.DeleteJSONSchema(ar.DB, id)
+	if errors.Is(err, db.ErrJSONSchemaNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("JSON schema with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete JSON schema: %v", err))
+		return
+	}
+
+	ar.RestartChan <- struct{}{}
+
+	Respond_With_JSON(w, http.StatusOK, fmt.Sprintf("JSON schema with ID %d deleted successfully", id))
+}
+
+// PostJSONSchemaValidate checks the request body against a schema without storing anything.
+func PostJSONSchemaValidate(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	stored, err := db.GetJSONSchema(ar.DB, id)
+	if errors.Is(err, db.ErrJSONSchemaNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("JSON schema with ID %d not found", id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve JSON schema: %v", err))
+		return
+	}
+
+	schema, err := jsonschema.Compile(stored.Schema)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var payload interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	errs := schema.Validate(payload)
+	Respond_With_JSON(w, http.StatusOK, map[string]interface{}{"valid": len(errs) == 0, "errors": errs})
+}
+
+type jsonSchemaBindingRequest struct {
+	TopicFilter *string `json:"topic_filter"`
+	MessageID   *int    `json:"message_id"`
+}
+
+func GetJSONSchemaBindings(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := db.GetJSONSchema(ar.DB, id); errors.Is(err, db.ErrJSONSchemaNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("JSON schema with ID %d not found", id))
+		return
+	}
+
+	bindings, err := db.ListJSONSchemaBindings(ar.DB, id)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get JSON schema bindings: %v", err))
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, bindings)
+}
+
+// PostJSONSchemaBinding binds a schema to a topic filter, {"topic_filter": "sensors/+/temp"}, or to a
+// message, {"message_id": 3}.
+func PostJSONSchemaBinding(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req jsonSchemaBindingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	defer r.Body.Close()
+
+	if (req.TopicFilter == nil) == (req.MessageID == nil) {
+		Respond_With_JSON(w, http.StatusBadRequest, "Exactly one of topic_filter and message_id is required")
+		return
+	}
+	if req.TopicFilter != nil {
+		if err := mqtttopic.ValidateFilter(*req.TopicFilter); err != nil {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid topic_filter %q: %v", *req.TopicFilter, err))
+			return
+		}
+	}
+
+	binding, err := db.CreateJSONSchemaBinding(ar.DB, db.JSONSchemaBinding{SchemaID: id, TopicFilter: req.TopicFilter, MessageID: req.MessageID})
+	if errors.Is(err, db.ErrJSONSchemaNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("JSON schema with ID %d not found", id))
+		return
+	}
+	if errors.Is(err, db.ErrMessageNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Message with ID %d not found", *req.MessageID))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JSON schema binding: %v", err))
+		return
+	}
+
+	ar.RestartChan <- struct{}{}
+
+	Respond_With_JSON(w, http.StatusOK, binding)
+}
+
+func DeleteJSONSchemaBinding(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.DB == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Database connection not available")
+		return
+	}
+
+	id, ok := idFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	bindingID, err := strconv.Atoi(mux.Vars(r)["binding"])
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'binding' parameter")
+		return
+	}
+
+	err = db.DeleteJSONSchemaBinding(ar.DB, id, bindingID)
+	if errors.Is(err, db.ErrJSONSchemaBindingNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, fmt.Sprintf("Binding %d of JSON schema %d not found", bindingID, id))
+		return
+	}
+	if err != nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete JSON schema binding: %v", err))
+		return
+	}
+
+	ar.RestartChan <- struct{}{}
+
+	Respond_With_JSON(w, http.StatusOK, fmt.Sprintf("Binding %d of JSON schema %d deleted successfully", bindingID, id))
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mqtt-mochi-server/broker"
+	"mqtt-mochi-server/jsonpath"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+const (
+	sseBuffer        = 256
+	sseKeepAlive     = 15 * time.Second
+	defaultWaitLimit = 30 * time.Second
+	maxWaitLimit     = 5 * time.Minute
+)
+
+type brokerMessage struct {
+	Topic     string      `json:"topic"`
+	Data      interface{} `json:"data"`
+	Client    string      `json:"client"`
+	QoS       byte        `json:"qos"`
+	Retain    bool        `json:"retain"`
+	Size      int         `json:"size"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+func newBrokerMessage(p broker.Publish) brokerMessage {
+	return brokerMessage{Topic: p.Topic, Data: p.Data(), Client: p.Client, QoS: p.QoS, Retain: p.Retain, Size: p.Size, Timestamp: p.Timestamp}
+}
+
+// subscribeParams reads the filter, match and retained query parameters shared by GetSubscribe and GetWait.
+func subscribeParams(r *http.Request) (string, *jsonpath.Expr, bool, error) {
+	params := r.URL.Query()
+
+	filter := params.Get("filter")
+	if filter == "" {
+		return "", nil, false, fmt.Errorf("Missing 'filter' parameter")
+	}
+	if err := mqtttopic.ValidateFilter(filter); err != nil {
+		return "", nil, false, fmt.Errorf("Invalid 'filter' parameter: %v", err)
+	}
+
+	var match *jsonpath.Expr
+	if expr := params.Get("match"); expr != "" {
+		var err error
+		if match, err = jsonpath.Compile(expr); err != nil {
+			return "", nil, false, fmt.Errorf("Invalid 'match' parameter: %v", err)
+		}
+	}
+
+	retained := true
+	if value := params.Get("retained"); value != "" {
+		var err error
+		if retained, err = strconv.ParseBool(value); err != nil {
+			return "", nil, false, fmt.Errorf("Invalid 'retained' parameter, expected true or false")
+		}
+	}
+
+	return filter, match, retained, nil
+}
+
+// GetSubscribe streams the broker messages matching `filter`, and `match` when given, as Server-Sent Events.
+func GetSubscribe(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	filter, match, retained, err := subscribeParams(r)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sub, err := ar.Broker.Subscribe(filter, retained, sseBuffer)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'filter' parameter: %v", err))
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": subscribed to %s\n\n", filter)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	var id uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprintf(w, ": keep-alive, %d dropped\n\n", sub.Dropped())
+			flusher.Flush()
+		case p := <-sub.C:
+			if match != nil && !match.MatchJSON(p.Payload) {
+				continue
+			}
+
+			data, err := json.Marshal(newBrokerMessage(p))
+			if err != nil {
+				continue
+			}
+
+			id++
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", id, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// GetWait blocks until a broker message matches `filter`, and `match` when given, and returns it. It gives up
+// after `timeout`, 30s by default, with a 504, since clients and proxies may retry a 408 on their own.
+func GetWait(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	filter, match, retained, err := subscribeParams(r)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	timeout := defaultWaitLimit
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 || timeout > maxWaitLimit {
+			Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'timeout' parameter, expected a duration such as 10s, up to %s", maxWaitLimit))
+			return
+		}
+	}
+
+	sub, err := ar.Broker.Subscribe(filter, retained, sseBuffer)
+	if err != nil {
+		Respond_With_JSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'filter' parameter: %v", err))
+		return
+	}
+	defer sub.Close()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			Respond_With_JSON(w, http.StatusGatewayTimeout, fmt.Sprintf("No matching message within %s", timeout))
+			return
+		case p := <-sub.C:
+			if match != nil && !match.MatchJSON(p.Payload) {
+				continue
+			}
+			Respond_With_JSON(w, http.StatusOK, newBrokerMessage(p))
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"mqtt-mochi-server/broker"
+	"mqtt-mochi-server/db"
+)
+
+// GetTopicTree returns the topic hierarchy of the configured messages and of the broker traffic, from the
+// `prefix` topic (the root by default) down `depth` levels, 1 by default and 0 for the whole tree.
+func GetTopicTree(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.Broker == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "Broker not available")
+		return
+	}
+
+	params := r.URL.Query()
+	depth := 1
+	if value := params.Get("depth"); value != "" {
+		var err error
+		if depth, err = strconv.Atoi(value); err != nil || depth < 0 {
+			Respond_With_JSON(w, http.StatusBadRequest, "Invalid 'depth' parameter, expected a positive number or 0 for the whole tree")
+			return
+		}
+	}
+
+	var configured []string
+	if ar.DB != nil {
+		var err error
+		if configured, err = db.GetTopics(ar.DB); err != nil {
+			Respond_With_JSON(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get topics: %v", err))
+			return
+		}
+	}
+
+	tree, err := ar.Broker.TopicTree(configured, params.Get("prefix"), depth)
+	if errors.Is(err, broker.ErrTopicNotFound) {
+		Respond_With_JSON(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, tree)
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// GetWSStats reports what the WS hub delivered and dropped, overall and for each connected client.
+func GetWSStats(w http.ResponseWriter, r *http.Request) {
+	ar, ok := r.Context().Value("appRouter").(*AppRouter)
+	if !ok || ar == nil || ar.WSHub == nil {
+		Respond_With_JSON(w, http.StatusInternalServerError, "WebSocket hub not available")
+		return
+	}
+
+	Respond_With_JSON(w, http.StatusOK, ar.WSHub.Stats())
+}
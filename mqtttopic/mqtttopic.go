@@ -0,0 +1,174 @@
+// Package mqtttopic validates MQTT topic names and filters, and matches topics against filters, as the
+// MQTT specification defines them. It is shared by everything that takes a topic or a filter: the API, the
+// WS subscriptions, the traffic inspector and the broker ACL.
+package mqtttopic
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the longest topic or filter MQTT can encode, in bytes.
+const MaxLength = 65535
+
+const sharePrefix = "$share/"
+
+// ValidateTopic checks a topic to publish on: non-empty UTF-8 of at most MaxLength bytes, without null or
+// control characters, without wildcards, and not starting with '$', which is reserved for the broker.
+func ValidateTopic(topic string) error {
+	if err := validString(topic); err != nil {
+		return err
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return errors.New("wildcards '+' and '#' are not allowed in a topic to publish on")
+	}
+	if strings.HasPrefix(topic, "$") {
+		return errors.New("topics starting with '$' are reserved for the broker")
+	}
+	return nil
+}
+
+// ValidateFilter checks a topic filter: '+' must fill a whole level, and '#' the last one. Shared
+// subscriptions, $share/{group}/{filter}, need a group without wildcards and a valid filter.
+func ValidateFilter(filter string) error {
+	if err := validString(filter); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(filter, sharePrefix) {
+		group, shared, ok := strings.Cut(strings.TrimPrefix(filter, sharePrefix), "/")
+		if !ok || group == "" {
+			return errors.New("a shared subscription needs a group and a filter, $share/{group}/{filter}")
+		}
+		if strings.ContainsAny(group, "+#") {
+			return errors.New("the group of a shared subscription can't hold wildcards")
+		}
+		if shared == "" {
+			return errors.New("a shared subscription needs a filter after its group")
+		}
+		filter = shared
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return errors.New("'#' must be the last level of the filter")
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return errors.New("'+' must fill a whole level of the filter")
+		}
+	}
+
+	return nil
+}
+
+func validString(s string) error {
+	if s == "" {
+		return errors.New("must not be empty")
+	}
+	if len(s) > MaxLength {
+		return fmt.Errorf("must be at most %d bytes long, got %d", MaxLength, len(s))
+	}
+	if !utf8.ValidString(s) {
+		return errors.New("must be valid UTF-8")
+	}
+	for i, r := range s {
+		if r == 0 {
+			return fmt.Errorf("null character at byte %d", i)
+		}
+		if r < 0x20 || (r >= 0x7f && r <= 0x9f) {
+			return fmt.Errorf("control character %U at byte %d", r, i)
+		}
+	}
+	return nil
+}
+
+// Match reports whether topic matches filter. Shared subscriptions match as their filter does, and, as in
+// MQTT, wildcards in the first level do not match topics starting with '$'.
+func Match(filter, topic string) bool {
+	filter = unshare(filter)
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	return MatchLevels(filter, topic)
+}
+
+// MatchLevels matches topic against filter level by level, with no special case for '$' topics. "a/#"
+// matches "a" as well as its sub-topics.
+func MatchLevels(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+// Regexp returns an anchored regular expression matching the topics that filter matches, written in the
+// syntax Go and PostgreSQL share, so that a database can match stored topics.
+func Regexp(filter string) string {
+	filter = unshare(filter)
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i, level := range strings.Split(filter, "/") {
+		switch {
+		case level == "#" && i == 0:
+			b.WriteString(`([^$].*)?`)
+		case level == "#":
+			b.WriteString(`(/.*)?`)
+		case level == "+" && i == 0:
+			b.WriteString(`([^$/][^/]*)?`)
+		default:
+			if i > 0 {
+				b.WriteString("/")
+			}
+			if level == "+" {
+				b.WriteString(`[^/]*`)
+			} else {
+				b.WriteString(regexp.QuoteMeta(level))
+			}
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Prefix returns what every topic matching filter starts with, the whole filter when it has no wildcard.
+func Prefix(filter string) string {
+	filter = unshare(filter)
+
+	i := strings.IndexAny(filter, "+#")
+	if i < 0 {
+		return filter
+	}
+	prefix := filter[:i]
+	if filter[i] == '#' {
+		// "a/#" matches "a" too
+		prefix = strings.TrimSuffix(prefix, "/")
+	}
+	return prefix
+}
+
+// unshare returns the filter of a shared subscription, or filter itself.
+func unshare(filter string) string {
+	if strings.HasPrefix(filter, sharePrefix) {
+		if _, shared, ok := strings.Cut(strings.TrimPrefix(filter, sharePrefix), "/"); ok {
+			return shared
+		}
+	}
+	return filter
+}
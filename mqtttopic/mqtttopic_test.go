@@ -0,0 +1,124 @@
+package mqtttopic
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidateTopic(t *testing.T) {
+	for topic, valid := range map[string]bool{
+		"a":                              true,
+		"a/b/c":                          true,
+		"/a/":                            true,
+		"température/salon":              true,
+		"":                               false,
+		"a/+":                            false,
+		"a/#":                            false,
+		"$SYS/broker":                    false,
+		"a\x00b":                         false,
+		"a\nb":                           false,
+		"a\u0085b":                       false,
+		"\xff":                           false,
+		strings.Repeat("a", MaxLength):   true,
+		strings.Repeat("a", MaxLength+1): false,
+	} {
+		if err := ValidateTopic(topic); (err == nil) != valid {
+			t.Errorf("ValidateTopic(%.20q) = %v, want valid %v", topic, err, valid)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	for filter, valid := range map[string]bool{
+		"a/b":            true,
+		"#":              true,
+		"+":              true,
+		"a/+/c":          true,
+		"a/#":            true,
+		"+/+/#":          true,
+		"$SYS/#":         true,
+		"$share/g/a/+":   true,
+		"$share/g/#":     true,
+		"":               false,
+		"a/#/c":          false,
+		"a#":             false,
+		"a/b+":           false,
+		"$share/g":       false,
+		"$share//a":      false,
+		"$share/g/":      false,
+		"$share/g+/a":    false,
+		"$share/g/a/#/b": false,
+	} {
+		if err := ValidateFilter(filter); (err == nil) != valid {
+			t.Errorf("ValidateFilter(%q) = %v, want valid %v", filter, err, valid)
+		}
+	}
+}
+
+var matchCases = []struct {
+	filter, topic string
+	match         bool
+}{
+	{"a/b", "a/b", true},
+	{"a/b", "a/c", false},
+	{"a/b", "a/b/c", false},
+	{"a/+", "a/b", true},
+	{"a/+", "a", false},
+	{"a/+", "a/", true},
+	{"a/+", "a/b/c", false},
+	{"+/+", "a/b", true},
+	{"+", "/a", false},
+	{"a/#", "a", true},
+	{"a/#", "a/b/c", true},
+	{"a/#", "ab", false},
+	{"#", "a/b", true},
+	{"#", "$SYS/broker", false},
+	{"+/broker", "$SYS/broker", false},
+	{"$SYS/#", "$SYS/broker", true},
+	{"$share/g/a/+", "a/b", true},
+	{"$share/g/#", "a", true},
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range matchCases {
+		if got := Match(c.filter, c.topic); got != c.match {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.filter, c.topic, got, c.match)
+		}
+	}
+
+	// MatchLevels has no special case for '$' topics
+	if !MatchLevels("#", "$SYS/broker") || !MatchLevels("+/broker", "$SYS/broker") {
+		t.Error("MatchLevels should match '$' topics against first level wildcards")
+	}
+}
+
+// The regular expression must agree with Match, for a database to select what the broker would deliver
+func TestRegexp(t *testing.T) {
+	for _, c := range matchCases {
+		re := regexp.MustCompile(Regexp(c.filter))
+		if got := re.MatchString(c.topic); got != c.match {
+			t.Errorf("Regexp(%q) = %s matches %q: %v, want %v", c.filter, re, c.topic, got, c.match)
+		}
+	}
+
+	if re := regexp.MustCompile(Regexp("a.b/+")); re.MatchString("axb/c") {
+		t.Error("Regexp should quote the characters of the filter")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for filter, want := range map[string]string{
+		"a/b":          "a/b",
+		"a/+/c":        "a/",
+		"a/#":          "a",
+		"a/b/#":        "a/b",
+		"#":            "",
+		"+":            "",
+		"$share/g/a/#": "a",
+	} {
+		if got := Prefix(filter); got != want {
+			t.Errorf("Prefix(%q) = %q, want %q", filter, got, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package openapi holds the OpenAPI 3.1 document of the REST API, served at /api/v1/openapi.json, and
+// compiles the request and response body schemas of its operations for the API to be validated against.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mqtt-mochi-server/jsonschema"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Document returns the OpenAPI document, as served.
+func Document() []byte {
+	return document
+}
+
+const (
+	schemasRef   = "#/components/schemas/"
+	responsesRef = "#/components/responses/"
+)
+
+// Spec is the compiled document, with its operations keyed on method and path, e.g. "GET /messages/{id}".
+type Spec struct {
+	operations map[string]*Operation
+}
+
+// Operation holds the JSON body schemas of an operation. RequestBody is nil when the operation takes no JSON
+// body, and Responses are keyed on status code, or "default", with nil for responses that are not JSON.
+type Operation struct {
+	ID          string
+	RequestBody *jsonschema.Schema
+	Responses   map[string]*jsonschema.Schema
+}
+
+type mediaTypes map[string]struct {
+	Schema json.RawMessage `json:"schema"`
+}
+
+type response struct {
+	Ref     string     `json:"$ref"`
+	Content mediaTypes `json:"content"`
+}
+
+type operation struct {
+	OperationID string `json:"operationId"`
+	RequestBody *struct {
+		Content mediaTypes `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]response `json:"responses"`
+}
+
+type doc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas   map[string]json.RawMessage `json:"schemas"`
+		Responses map[string]response        `json:"responses"`
+	} `json:"components"`
+}
+
+// Load compiles the embedded document.
+func Load() (*Spec, error) {
+	return Parse(document)
+}
+
+// Parse compiles an OpenAPI document. Component schemas are referenced as "#/components/schemas/{name}".
+func Parse(data []byte) (*Spec, error) {
+	// Components become $defs, which the jsonschema package resolves
+	data = []byte(strings.ReplaceAll(string(data), `"`+schemasRef, `"#/$defs/`))
+
+	var d doc
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+
+	// The body schemas are compiled at once, as more $defs next to the components, under keys that can't
+	// clash with a component name
+	defs := make(map[string]json.RawMessage, len(d.Components.Schemas))
+	for name, schema := range d.Components.Schemas {
+		defs[name] = schema
+	}
+	body := func(content mediaTypes) string {
+		media, ok := content["application/json"]
+		if !ok || len(media.Schema) == 0 {
+			return ""
+		}
+		key := fmt.Sprintf("body %d", len(defs))
+		defs[key] = media.Schema
+		return key
+	}
+
+	type bodies struct {
+		request   string
+		responses map[string]string
+	}
+	operations := make(map[string]*Operation)
+	keys := make(map[*Operation]bodies)
+	for path, item := range d.Paths {
+		for method, raw := range item {
+			if method == "parameters" || method == "summary" || method == "description" {
+				continue
+			}
+			var op operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("failed to parse %s %s: %w", method, path, err)
+			}
+
+			b := bodies{responses: make(map[string]string)}
+			if op.RequestBody != nil {
+				b.request = body(op.RequestBody.Content)
+			}
+			for status, resp := range op.Responses {
+				if name, ok := strings.CutPrefix(resp.Ref, responsesRef); ok {
+					if resp, ok = d.Components.Responses[name]; !ok {
+						return nil, fmt.Errorf("%s %s: unresolved $ref %q", method, path, responsesRef+name)
+					}
+				}
+				b.responses[status] = body(resp.Content)
+			}
+
+			compiled := &Operation{ID: op.OperationID, Responses: make(map[string]*jsonschema.Schema)}
+			operations[strings.ToUpper(method)+" "+path] = compiled
+			keys[compiled] = b
+		}
+	}
+
+	root, err := json.Marshal(map[string]interface{}{"$defs": defs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schemas: %w", err)
+	}
+	schema, err := jsonschema.Compile(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile OpenAPI schemas: %w", err)
+	}
+	for op, b := range keys {
+		op.RequestBody = schema.Defs[b.request]
+		for status, key := range b.responses {
+			op.Responses[status] = schema.Defs[key]
+		}
+	}
+
+	return &Spec{operations: operations}, nil
+}
+
+// Operation returns the operation of method on path, a path of the document such as "/messages/{id}", or nil.
+func (s *Spec) Operation(method, path string) *Operation {
+	return s.operations[method+" "+path]
+}
+
+// pathVariable matches a mux variable with a pattern, e.g. {id:.+}, which the document writes {id}.
+var pathVariable = regexp.MustCompile(`\{([^}:]+):[^}]*\}`)
+
+// Route returns the operation of method on a mux path template, such as "/broker/clients/{id:.+}", or nil.
+func (s *Spec) Route(method, template string) *Operation {
+	path := pathVariable.ReplaceAllString(template, "{$1}")
+	if path == "" {
+		path = "/"
+	}
+	return s.Operation(method, path)
+}
+
+// Response returns the schema of the response with the status code, falling back to the default response,
+// or nil when the response is not JSON.
+func (o *Operation) Response(status int) *jsonschema.Schema {
+	if schema, ok := o.Responses[fmt.Sprint(status)]; ok {
+		return schema
+	}
+	return o.Responses["default"]
+}
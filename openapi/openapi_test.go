@@ -0,0 +1,98 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func load(t *testing.T) *Spec {
+	t.Helper()
+	spec, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return spec
+}
+
+func valid(t *testing.T, op *Operation, status int, doc string) bool {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		t.Fatalf("invalid test document %s: %v", doc, err)
+	}
+	schema := op.RequestBody
+	if status != 0 {
+		schema = op.Response(status)
+	}
+	if schema == nil {
+		t.Fatalf("%s has no schema for status %d", op.ID, status)
+	}
+	return len(schema.Validate(value)) == 0
+}
+
+func TestRoute(t *testing.T) {
+	spec := load(t)
+	for template, path := range map[string]string{
+		"/messages":                   "/messages",
+		"/messages/{id}":              "/messages/{id}",
+		"/broker/clients/{id:.+}":     "/broker/clients/{id}",
+		"/broker/retained/{topic:.+}": "/broker/retained/{topic}",
+	} {
+		op := spec.Route("GET", template)
+		if op == nil || op != spec.Operation("GET", path) {
+			t.Errorf("Route(GET, %s) = %+v, want the operation of %s", template, op, path)
+		}
+	}
+	if spec.Route("PATCH", "/messages") != nil || spec.Route("GET", "/missing") != nil {
+		t.Error("Route should be nil for operations the document does not have")
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	op := load(t).Operation("POST", "/messages")
+	for doc, want := range map[string]bool{
+		`{"topic": "a/b", "payload": {"temp": 1}, "frequency": 5}`: true,
+		`{"topic": "a/b"}`:                 true,
+		`{"payload": 1}`:                   false,
+		`{"topic": ""}`:                    false,
+		`{"topic": "a", "frequency": -1}`:  false,
+		`{"topic": "a", "frequency": 1.5}`: false,
+	} {
+		if got := valid(t, op, 0, doc); got != want {
+			t.Errorf("POST /messages request %s: valid = %v, want %v", doc, got, want)
+		}
+	}
+}
+
+func TestResponses(t *testing.T) {
+	op := load(t).Operation("POST", "/messages")
+	if !valid(t, op, 200, `{"status": "success", "message": "", "data": "created"}`) {
+		t.Error("the 200 response of POST /messages should be valid")
+	}
+	if valid(t, op, 200, `{"status": "success", "message": "", "data": 1}`) {
+		t.Error("the 200 response of POST /messages should want a string")
+	}
+
+	// Statuses the operation does not list fall back to the default response, through its $ref
+	if op.Response(503) == nil || op.Response(503) != op.Responses["default"] {
+		t.Error("Response(503) should be the default response")
+	}
+	if !valid(t, op, 503, `{"status": "error", "message": "unavailable", "data": null}`) {
+		t.Error("the default error response should be valid")
+	}
+	if valid(t, op, 503, `{"status": "success", "message": "", "data": null}`) {
+		t.Error("the default error response should want status error")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, doc := range []string{
+		`not json`,
+		`{"paths": {"/a": {"get": {"responses": {"200": {"$ref": "#/components/responses/Missing"}}}}}}`,
+		`{"paths": {"/a": {"get": {"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Missing"}}}}}}}}}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("Parse(%s) succeeded, want an error", doc)
+		}
+	}
+}
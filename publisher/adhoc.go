@@ -0,0 +1,240 @@
+package publisher
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+// Payload encodings of ad-hoc publishes.
+const (
+	EncodingJSON   = "json"
+	EncodingText   = "text"
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+// ErrInvalidPublish is wrapped by the errors of ad-hoc publishes that cannot be sent as requested.
+var ErrInvalidPublish = errors.New("invalid publish")
+
+// PublishRequest is an ad-hoc message, published once without being stored.
+type PublishRequest struct {
+	Topic string `json:"topic"`
+	// Payload strings are rendered as templates, as are the strings inside JSON objects and arrays
+	Payload interface{} `json:"payload"`
+	// Encoding is json, text, base64 or hex. It defaults to text for string payloads, json otherwise.
+	Encoding   string             `json:"encoding"`
+	QoS        byte               `json:"qos"`
+	Retain     bool               `json:"retain"`
+	Properties *PublishProperties `json:"properties"`
+	// Broker is published to instead of the embedded broker when set
+	Broker *RemoteBroker `json:"broker"`
+}
+
+// PublishProperties are the MQTT v5 properties of an ad-hoc message.
+type PublishProperties struct {
+	ContentType           string         `json:"content_type"`
+	ResponseTopic         string         `json:"response_topic"`
+	CorrelationData       string         `json:"correlation_data"`
+	MessageExpiryInterval uint32         `json:"message_expiry_interval"`
+	PayloadFormat         *byte          `json:"payload_format"`
+	UserProperties        []UserProperty `json:"user_properties"`
+}
+
+type UserProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PublishResult reports an ad-hoc publish, with its payload as rendered.
+type PublishResult struct {
+	Topic       string    `json:"topic"`
+	Payload     string    `json:"payload"`
+	Encoding    string    `json:"encoding"`
+	Size        int       `json:"size"`
+	QoS         byte      `json:"qos"`
+	Retain      bool      `json:"retain"`
+	Broker      string    `json:"broker"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func invalidPublish(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPublish, fmt.Sprintf(format, args...))
+}
+
+// render returns the payload to send, along with the rendered text reported back and shown to WS clients.
+func (req *PublishRequest) render() ([]byte, string, interface{}, error) {
+	text, isText := req.Payload.(string)
+
+	if req.Encoding == "" {
+		req.Encoding = EncodingJSON
+		if isText {
+			req.Encoding = EncodingText
+		}
+	}
+
+	if !isText {
+		if req.Encoding != EncodingJSON {
+			return nil, "", nil, invalidPublish("%s payloads must be strings", req.Encoding)
+		}
+
+		rendered, err := renderValue(req.Payload)
+		if err != nil {
+			return nil, "", nil, invalidPublish("%v", err)
+		}
+		data, err := json.Marshal(rendered)
+		if err != nil {
+			return nil, "", nil, invalidPublish("failed to marshal payload: %v", err)
+		}
+		return data, string(data), rendered, nil
+	}
+
+	rendered, err := Render(text)
+	if err != nil {
+		return nil, "", nil, invalidPublish("%v", err)
+	}
+
+	switch req.Encoding {
+	case EncodingJSON:
+		if !json.Valid([]byte(rendered)) {
+			return nil, "", nil, invalidPublish("rendered payload is not valid JSON: %s", rendered)
+		}
+		return []byte(rendered), rendered, json.RawMessage(rendered), nil
+	case EncodingText:
+		return []byte(rendered), rendered, rendered, nil
+	case EncodingBase64:
+		data, err := base64.StdEncoding.DecodeString(rendered)
+		if err != nil {
+			return nil, "", nil, invalidPublish("invalid base64 payload: %v", err)
+		}
+		return data, rendered, rendered, nil
+	case EncodingHex:
+		data, err := hex.DecodeString(rendered)
+		if err != nil {
+			return nil, "", nil, invalidPublish("invalid hex payload: %v", err)
+		}
+		return data, rendered, rendered, nil
+	default:
+		return nil, "", nil, invalidPublish("unknown encoding %q, expected json, text, base64 or hex", req.Encoding)
+	}
+}
+
+func (p *PublishProperties) packetProperties() (packets.Properties, error) {
+	props := packets.Properties{
+		ContentType:           p.ContentType,
+		ResponseTopic:         p.ResponseTopic,
+		MessageExpiryInterval: p.MessageExpiryInterval,
+	}
+	if p.CorrelationData != "" {
+		props.CorrelationData = []byte(p.CorrelationData)
+	}
+	if p.ResponseTopic != "" {
+		if err := mqtttopic.ValidateTopic(p.ResponseTopic); err != nil {
+			return props, invalidPublish("invalid properties.response_topic %q: %v", p.ResponseTopic, err)
+		}
+	}
+	if p.PayloadFormat != nil {
+		if *p.PayloadFormat > 1 {
+			return props, invalidPublish("properties.payload_format: must be 0 or 1, got %d", *p.PayloadFormat)
+		}
+		props.PayloadFormat = *p.PayloadFormat
+		props.PayloadFormatFlag = true
+	}
+	for _, up := range p.UserProperties {
+		props.User = append(props.User, packets.UserProperty{Key: up.Key, Val: up.Value})
+	}
+	return props, nil
+}
+
+// Publish sends an ad-hoc message, recorded in the publish history without a message ID. Errors wrapping
+// ErrInvalidPublish mean nothing was sent; a failed delivery is reported in the result.
+func (m *Manager) Publish(req PublishRequest) (PublishResult, error) {
+	if req.Topic == "" {
+		return PublishResult{}, invalidPublish("topic is required")
+	}
+	if err := mqtttopic.ValidateTopic(req.Topic); err != nil {
+		return PublishResult{}, invalidPublish("invalid topic %q: %v", req.Topic, err)
+	}
+	if req.QoS > 2 {
+		return PublishResult{}, invalidPublish("invalid qos %d, expected 0, 1 or 2", req.QoS)
+	}
+
+	data, text, shown, err := req.render()
+	if err != nil {
+		return PublishResult{}, err
+	}
+
+	pk := packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: req.QoS, Retain: req.Retain},
+		TopicName:   req.Topic,
+		Payload:     data,
+		PacketID:    uint16(req.QoS),
+	}
+	if req.Properties != nil {
+		if pk.Properties, err = req.Properties.packetProperties(); err != nil {
+			return PublishResult{}, err
+		}
+	}
+
+	result := PublishResult{
+		Topic:       req.Topic,
+		Payload:     text,
+		Encoding:    req.Encoding,
+		Size:        len(data),
+		QoS:         req.QoS,
+		Retain:      req.Retain,
+		Broker:      "embedded",
+		Outcome:     db.PublishOutcomeSuccess,
+		PublishedAt: time.Now(),
+	}
+
+	send := func() error { return m.inject(pk) }
+	if req.Broker != nil {
+		broker := *req.Broker
+		if broker.ProtocolVersion == 0 {
+			broker.ProtocolVersion = 4
+			if req.Properties != nil {
+				broker.ProtocolVersion = 5
+			}
+		}
+		if err := broker.validate(); err != nil {
+			return PublishResult{}, invalidPublish("%v", err)
+		}
+		if req.Properties != nil && broker.ProtocolVersion != 5 {
+			return PublishResult{}, invalidPublish("properties need broker.protocol_version 5")
+		}
+		result.Broker = broker.Address
+		send = func() error { return broker.publish(pk) }
+	}
+
+	if err := m.publish(nil, req.Topic, data, shown, req.QoS, send); err != nil {
+		result.Outcome = db.PublishOutcomeError
+		result.Error = err.Error()
+	}
+
+	return result, nil
+}
+
+// inject publishes pk on the embedded broker as the inline client, with its v5 properties.
+func (m *Manager) inject(pk packets.Packet) error {
+	m.mu.Lock()
+	if m.inline == nil {
+		m.inline = m.Server.NewClient(nil, mqtt.LocalListener, mqtt.InlineClientId, true)
+		m.inline.Properties.ProtocolVersion = 5
+	}
+	cl := m.inline
+	m.mu.Unlock()
+
+	return m.Server.InjectPacket(cl, pk)
+}
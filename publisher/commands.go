@@ -0,0 +1,58 @@
+package publisher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Command is sent by WS clients to drive the simulator, e.g. {"id":"1","op":"stop","message_id":3}. Publish
+// commands are decoded as a PublishRequest.
+type Command struct {
+	Op        string      `json:"op"`
+	MessageID *int        `json:"message_id"`
+	Field     string      `json:"field"`
+	Value     interface{} `json:"value"`
+}
+
+// HandleCommand runs the command of a WS client and returns the result of its reply.
+func (m *Manager) HandleCommand(op string, data []byte) (interface{}, error) {
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, fmt.Errorf("invalid command: %w", err)
+	}
+
+	switch op {
+	case "start":
+		return m.Start(cmd.MessageID)
+	case "stop":
+		return m.Stop(cmd.MessageID)
+	case "status":
+		return m.Status(), nil
+	case "publish":
+		var req PublishRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, fmt.Errorf("invalid command: %w", err)
+		}
+		result, err := m.Publish(req)
+		if err != nil {
+			return nil, err
+		}
+		if result.Error != "" {
+			return nil, errors.New(result.Error)
+		}
+		return result, nil
+	case "override":
+		if cmd.MessageID == nil {
+			return nil, errors.New("message_id is required")
+		}
+		return m.SetOverride(*cmd.MessageID, cmd.Field, cmd.Value)
+	case "clear_override":
+		if cmd.MessageID == nil {
+			return nil, errors.New("message_id is required")
+		}
+		return m.ClearOverride(*cmd.MessageID, cmd.Field)
+	default:
+		return nil, fmt.Errorf("unknown op %q, expected subscribe, unsubscribe, start, stop, status, publish, override or clear_override", op)
+	}
+}
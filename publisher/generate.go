@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/jsonschema"
+)
+
+// generateKey marks a payload generated from a registered JSON schema on each publish, with fields pinned to
+// values or templates, e.g. {"$generate": {"schema_id": 3, "fields": {"device.id": "sensor-{{randInt 1 9}}"}}}.
+const generateKey = "$generate"
+
+// ErrInvalidPayload is returned for a payload that can't be published, such as one generated from an unknown schema.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+type generateSpec struct {
+	SchemaID int                    `json:"schema_id"`
+	Fields   map[string]interface{} `json:"fields"`
+}
+
+// generateSpecOf reads the generation spec of a payload, or returns nil when the payload is published as is.
+func generateSpecOf(payload interface{}) (*generateSpec, error) {
+	object, ok := payload.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	raw, ok := object[generateKey]
+	if !ok {
+		return nil, nil
+	}
+	if len(object) != 1 {
+		return nil, fmt.Errorf("%w: %s must be the only field of the payload", ErrInvalidPayload, generateKey)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	var spec generateSpec
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, generateKey, err)
+	}
+	if spec.SchemaID <= 0 {
+		return nil, fmt.Errorf("%w: %s requires a schema_id", ErrInvalidPayload, generateKey)
+	}
+	return &spec, nil
+}
+
+// loadGenerators reads and compiles every registered schema, by ID. Schemas that no longer compile are
+// skipped and reported.
+func loadGenerators(dbConn *sql.DB) (map[int]*jsonschema.Schema, []error, error) {
+	rows, err := db.ListJSONSchemas(dbConn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	generators := make(map[int]*jsonschema.Schema, len(rows))
+	var invalid []error
+	for _, row := range rows {
+		schema, err := jsonschema.Compile(row.Schema)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("schema %d (%s): %w", row.ID, row.Name, err))
+			continue
+		}
+		generators[row.ID] = schema
+	}
+	return generators, invalid, nil
+}
+
+// generatePayload returns a copy of payload, or a fresh value of its schema with its pinned fields when it is
+// generated. Pinned strings are rendered as templates, and read as JSON when the schema expects no string
+// there, so that "{{randInt 1 9}}" pins a number.
+func generatePayload(payload interface{}, generators map[int]*jsonschema.Schema) (interface{}, error) {
+	spec, err := generateSpecOf(payload)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return clone(payload), nil
+	}
+
+	schema, ok := generators[spec.SchemaID]
+	if !ok {
+		return nil, fmt.Errorf("%w: JSON schema %d not found", ErrInvalidPayload, spec.SchemaID)
+	}
+
+	generated, errs, err := schema.Generate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to generate from JSON schema %d: %v", ErrInvalidPayload, spec.SchemaID, err)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: no value generated from JSON schema %d conforms to it: %v", ErrInvalidPayload, spec.SchemaID, errs[0])
+	}
+
+	for field, value := range spec.Fields {
+		path := strings.Split(field, ".")
+		rendered, err := renderValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: field %s: %v", ErrInvalidPayload, field, err)
+		}
+		if text, ok := rendered.(string); ok && !expectsString(schema.Field(path)) {
+			var decoded interface{}
+			if json.Unmarshal([]byte(text), &decoded) == nil {
+				rendered = decoded
+			}
+		}
+		generated = setField(generated, path, rendered)
+	}
+
+	return generated, nil
+}
+
+// expectsString reports whether a field may hold a string, which it may when the schema does not say.
+func expectsString(schema *jsonschema.Schema) bool {
+	if schema == nil || len(schema.Type) == 0 {
+		return true
+	}
+	for _, t := range schema.Type {
+		if t == "string" {
+			return true
+		}
+	}
+	return false
+}
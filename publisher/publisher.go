@@ -0,0 +1,443 @@
+package publisher
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/jsonschema"
+)
+
+// Events sent to OnEvent.
+const (
+	EventPublisherStarted = "publisher_started"
+	EventPublisherStopped = "publisher_stopped"
+	EventError            = "error"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+// Manager publishes the messages of the database at their frequency. Messages can be stopped and started
+// again, and fields of their payload overridden, while the simulator runs.
+type Manager struct {
+	Server *mqtt.Server
+	DB     *sql.DB
+
+	// OnPublish is called with each message the simulator published
+	OnPublish func(topic string, payload interface{})
+
+	// OnEvent is called when publishers start or stop, and when publishing fails
+	OnEvent func(event string, data interface{})
+
+	mu        sync.Mutex
+	running   map[int]context.CancelFunc
+	messages  map[int]db.Message
+	stopped   map[int]bool
+	overrides map[int]map[string]interface{}
+
+	// validate checks published payloads against the bound schemas, loaded when the publishers start
+	validate   atomic.Bool
+	schemas    []boundSchema
+	violations map[int]uint64
+
+	// generators are the compiled schemas by ID, for the payloads generated on each publish
+	generators map[int]*jsonschema.Schema
+
+	// inline publishes the ad-hoc messages, as a v5 client to keep their properties
+	inline *mqtt.Client
+}
+
+// Status is the state of the publisher of a message.
+type Status struct {
+	MessageID int                    `json:"message_id"`
+	Topic     string                 `json:"topic"`
+	Frequency int                    `json:"frequency"`
+	Running   bool                   `json:"running"`
+	Overrides map[string]interface{} `json:"overrides,omitempty"`
+	// Violations counts the published payloads that broke a bound schema, when payload validation is on
+	Violations uint64 `json:"violations,omitempty"`
+}
+
+func New(server *mqtt.Server, dbConn *sql.DB) *Manager {
+	return &Manager{
+		Server:     server,
+		DB:         dbConn,
+		running:    make(map[int]context.CancelFunc),
+		messages:   make(map[int]db.Message),
+		stopped:    make(map[int]bool),
+		overrides:  make(map[int]map[string]interface{}),
+		violations: make(map[int]uint64),
+	}
+}
+
+// Run starts the publishers, and starts them again from the database each time restart receives.
+func (m *Manager) Run(restart <-chan struct{}) {
+	m.mu.Lock()
+	m.startPublishing()
+	m.mu.Unlock()
+
+	for range restart {
+		m.Server.Log.Info("Restarting publisher due to new message.")
+		m.mu.Lock()
+		m.stopPublishing()
+		m.startPublishing()
+		m.mu.Unlock()
+	}
+}
+
+func (m *Manager) stopPublishing() {
+	for id, cancel := range m.running {
+		cancel()
+		delete(m.running, id)
+	}
+}
+
+func (m *Manager) startPublishing() {
+	messages, err := db.FetchMessages(m.DB)
+	if err != nil {
+		m.Server.Log.Error("Failed to fetch messages from database", "error", err)
+		m.event(EventError, map[string]string{"error": fmt.Sprintf("failed to fetch messages: %v", err)})
+		return
+	}
+
+	m.messages = make(map[int]db.Message, len(messages))
+	for _, msg := range messages {
+		m.messages[msg.ID] = msg
+	}
+
+	schemas, invalid, err := loadSchemas(m.DB)
+	if err != nil {
+		m.Server.Log.Error("Failed to load JSON schemas from database", "error", err)
+		m.event(EventError, map[string]string{"error": fmt.Sprintf("failed to load JSON schemas: %v", err)})
+	}
+	for _, err := range invalid {
+		m.Server.Log.Warn("Skipping invalid JSON schema", "error", err)
+	}
+	m.schemas = schemas
+
+	generators, invalid, err := loadGenerators(m.DB)
+	if err != nil {
+		m.Server.Log.Error("Failed to load JSON schemas from database", "error", err)
+		m.event(EventError, map[string]string{"error": fmt.Sprintf("failed to load JSON schemas: %v", err)})
+	}
+	for _, err := range invalid {
+		m.Server.Log.Warn("Skipping invalid JSON schema", "error", err)
+	}
+	m.generators = generators
+
+	if len(messages) == 0 {
+		m.Server.Log.Info("No messages found in the database to publish.")
+		return
+	}
+
+	m.Server.Log.Info("Fetched messages from the database and starting to publish")
+
+	for _, msg := range messages {
+		if !m.stopped[msg.ID] {
+			m.start(msg)
+		}
+	}
+}
+
+// start publishes msg, at its frequency or once when it has none.
+func (m *Manager) start(msg db.Message) {
+	if msg.Frequency <= 0 {
+		go m.publishMessage(msg)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.running[msg.ID] = cancel
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(msg.Frequency) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.publishMessage(msg)
+			}
+		}
+	}()
+}
+
+// Start starts publishing the message with the given ID, or all messages when id is nil.
+func (m *Manager) Start(id *int) ([]Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == nil {
+		m.stopped = make(map[int]bool)
+		m.stopPublishing()
+		m.startPublishing()
+		m.event(EventPublisherStarted, m.statusLocked(nil))
+		return m.statusLocked(nil), nil
+	}
+
+	msg, ok := m.messages[*id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, *id)
+	}
+
+	delete(m.stopped, *id)
+	if _, ok := m.running[*id]; !ok {
+		m.start(msg)
+	}
+
+	status := m.statusLocked(id)
+	m.event(EventPublisherStarted, status)
+	return status, nil
+}
+
+// Stop stops publishing the message with the given ID, or all messages when id is nil. Stopped messages
+// stay stopped when the publishers restart, until they are started again. Messages created later start.
+func (m *Manager) Stop(id *int) ([]Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == nil {
+		for msgID := range m.messages {
+			m.stopped[msgID] = true
+		}
+		m.stopPublishing()
+		m.event(EventPublisherStopped, m.statusLocked(nil))
+		return m.statusLocked(nil), nil
+	}
+
+	if _, ok := m.messages[*id]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, *id)
+	}
+
+	m.stopped[*id] = true
+	if cancel, ok := m.running[*id]; ok {
+		cancel()
+		delete(m.running, *id)
+	}
+
+	status := m.statusLocked(id)
+	m.event(EventPublisherStopped, status)
+	return status, nil
+}
+
+// SetOverride replaces a field of the payload of a message, given by its dotted path, e.g. "sensor.temp",
+// until the override is cleared. Missing objects along the path are created.
+func (m *Manager) SetOverride(id int, field string, value interface{}) ([]Status, error) {
+	if field == "" {
+		return nil, errors.New("field is required")
+	}
+	for _, key := range strings.Split(field, ".") {
+		if key == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.messages[id]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, id)
+	}
+
+	if m.overrides[id] == nil {
+		m.overrides[id] = make(map[string]interface{})
+	}
+	m.overrides[id][field] = value
+
+	return m.statusLocked(&id), nil
+}
+
+// ClearOverride removes the override of a field, or all overrides of the message when field is empty.
+func (m *Manager) ClearOverride(id int, field string) ([]Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.messages[id]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, id)
+	}
+
+	if field == "" {
+		delete(m.overrides, id)
+	} else {
+		delete(m.overrides[id], field)
+		if len(m.overrides[id]) == 0 {
+			delete(m.overrides, id)
+		}
+	}
+
+	return m.statusLocked(&id), nil
+}
+
+// Status reports the publisher of each message, sorted by message ID.
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.statusLocked(nil)
+}
+
+func (m *Manager) statusLocked(id *int) []Status {
+	statuses := []Status{}
+	for _, msg := range m.messages {
+		if id != nil && msg.ID != *id {
+			continue
+		}
+
+		_, running := m.running[msg.ID]
+		status := Status{MessageID: msg.ID, Topic: msg.Topic, Frequency: msg.Frequency, Running: running, Violations: m.violations[msg.ID]}
+		if len(m.overrides[msg.ID]) > 0 {
+			status.Overrides = make(map[string]interface{}, len(m.overrides[msg.ID]))
+			for field, value := range m.overrides[msg.ID] {
+				status.Overrides[field] = value
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].MessageID < statuses[j].MessageID })
+	return statuses
+}
+
+func (m *Manager) event(event string, data interface{}) {
+	if m.OnEvent != nil {
+		m.OnEvent(event, data)
+	}
+}
+
+// payload returns the payload of msg to publish now, generated when it comes from a schema, with its overrides
+// and a fresh "ts" or "timestamp".
+func (m *Manager) payload(msg db.Message, generators map[int]*jsonschema.Schema) (interface{}, error) {
+	payload, err := generatePayload(msg.Payload, generators)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	for field, value := range m.overrides[msg.ID] {
+		payload = setField(payload, strings.Split(field, "."), clone(value))
+	}
+	m.mu.Unlock()
+
+	if payloadMap, ok := payload.(map[string]interface{}); ok {
+		if _, ok := payloadMap["ts"]; ok {
+			payloadMap["ts"] = time.Now().Unix()
+		} else if _, ok := payloadMap["timestamp"]; ok {
+			payloadMap["timestamp"] = time.Now().Unix()
+		}
+	}
+
+	return payload, nil
+}
+
+func (m *Manager) publishMessage(msg db.Message) {
+	m.mu.Lock()
+	generators := m.generators
+	m.mu.Unlock()
+
+	payload, err := m.payload(msg, generators)
+	if err != nil {
+		m.Server.Log.Error("Failed to build payload for publishing", "topic", msg.Topic, "error", err)
+		m.event(EventError, map[string]interface{}{"message_id": msg.ID, "topic": msg.Topic, "error": err.Error()})
+		return
+	}
+
+	if m.validate.Load() {
+		m.checkPublished(msg, payload)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		m.Server.Log.Error("Failed to marshal payload for publishing", "topic", msg.Topic, "error", err)
+		m.event(EventError, map[string]interface{}{"message_id": msg.ID, "topic": msg.Topic, "error": err.Error()})
+		return
+	}
+
+	id := msg.ID
+	m.publish(&id, msg.Topic, data, payload, 0, func() error {
+		return m.Server.Publish(msg.Topic, data, false, 0)
+	})
+}
+
+// publish sends data to the broker and records the attempt in the publish history.
+func (m *Manager) publish(messageID *int, topic string, data []byte, payload interface{}, qos byte, send func() error) error {
+	entry := db.PublishLogEntry{
+		MessageID:   messageID,
+		Topic:       topic,
+		Payload:     string(data),
+		QoS:         qos,
+		PublishedAt: time.Now(),
+		Outcome:     db.PublishOutcomeSuccess,
+	}
+
+	err := send()
+	if err != nil {
+		m.Server.Log.Error("Failed to publish message", "topic", topic, "error", err)
+		entry.Outcome = db.PublishOutcomeError
+		entry.Error = err.Error()
+
+		eventData := map[string]interface{}{"topic": topic, "error": err.Error()}
+		if messageID != nil {
+			eventData["message_id"] = *messageID
+		}
+		m.event(EventError, eventData)
+	} else {
+		m.Server.Log.Info("Published message", "topic", topic)
+		if m.OnPublish != nil {
+			m.OnPublish(topic, payload)
+		}
+	}
+
+	if m.DB != nil {
+		if err := db.LogPublish(m.DB, entry); err != nil {
+			m.Server.Log.Error("Failed to record publish in history", "topic", topic, "error", err)
+		}
+	}
+
+	return err
+}
+
+// clone copies the objects and arrays of a decoded JSON value, so overrides never touch the stored payload.
+func clone(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			c[key] = clone(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = clone(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// setField sets the field at path in value, replacing anything that is not an object along the way.
+func setField(value interface{}, path []string, field interface{}) interface{} {
+	if len(path) == 0 {
+		return field
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+	}
+	object[path[0]] = setField(object[path[0]], path[1:], field)
+	return object
+}
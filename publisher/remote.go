@@ -0,0 +1,237 @@
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+const defaultRemoteTimeout = 10 * time.Second
+
+// RemoteBroker is a broker other than the embedded one to publish to, e.g. {"address":"tls://broker:8883"}.
+// A connection is opened for each publish.
+type RemoteBroker struct {
+	// Address is host:port, or a URL with a tcp, mqtt, tls, ssl or mqtts scheme
+	Address            string `json:"address"`
+	ClientID           string `json:"client_id"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	ProtocolVersion    byte   `json:"protocol_version"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// Timeout in seconds for the whole exchange, 10 by default
+	Timeout int `json:"timeout"`
+}
+
+func (b *RemoteBroker) validate() error {
+	if b.Address == "" {
+		return fmt.Errorf("broker.address is required")
+	}
+	if _, _, err := b.endpoint(); err != nil {
+		return err
+	}
+	switch b.ProtocolVersion {
+	case 3, 4, 5:
+	default:
+		return fmt.Errorf("broker.protocol_version: must be 3, 4 or 5, got %d", b.ProtocolVersion)
+	}
+	if b.Timeout < 0 {
+		return fmt.Errorf("broker.timeout: must be positive, got %d", b.Timeout)
+	}
+	return nil
+}
+
+// endpoint returns the host:port to dial, and whether to use TLS.
+func (b *RemoteBroker) endpoint() (string, bool, error) {
+	u, err := url.Parse(b.Address)
+	if err != nil || u.Host == "" {
+		if _, _, err := net.SplitHostPort(b.Address); err != nil {
+			return "", false, fmt.Errorf("broker.address: invalid address %q", b.Address)
+		}
+		return b.Address, false, nil
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		return u.Host, false, nil
+	case "tls", "ssl", "mqtts":
+		return u.Host, true, nil
+	default:
+		return "", false, fmt.Errorf("broker.address: unknown scheme %q, expected tcp, mqtt, tls, ssl or mqtts", u.Scheme)
+	}
+}
+
+func (b *RemoteBroker) dial(timeout time.Duration) (net.Conn, error) {
+	address, useTLS, err := b.endpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		return tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host, InsecureSkipVerify: b.InsecureSkipVerify, MinVersion: tls.VersionTLS12})
+	}
+	return dialer.Dial("tcp", address)
+}
+
+// publish connects to the broker, sends pk and waits for its acknowledgement when its QoS asks for one.
+func (b *RemoteBroker) publish(pk packets.Packet) error {
+	timeout := defaultRemoteTimeout
+	if b.Timeout > 0 {
+		timeout = time.Duration(b.Timeout) * time.Second
+	}
+
+	conn, err := b.dial(timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", b.Address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	version := b.ProtocolVersion
+	protocolName := []byte("MQTT")
+	if version == 3 {
+		protocolName = []byte("MQIsdp")
+	}
+
+	clientID := b.ClientID
+	if clientID == "" {
+		id, err := uuid()
+		if err != nil {
+			return err
+		}
+		clientID = "mqtt-simulator-" + id[:8]
+	}
+
+	connect := packets.Packet{
+		FixedHeader:     packets.FixedHeader{Type: packets.Connect},
+		ProtocolVersion: version,
+		Connect: packets.ConnectParams{
+			ProtocolName:     protocolName,
+			ClientIdentifier: clientID,
+			Clean:            true,
+			Keepalive:        uint16(timeout / time.Second),
+			UsernameFlag:     b.Username != "",
+			Username:         []byte(b.Username),
+			PasswordFlag:     b.Password != "",
+			Password:         []byte(b.Password),
+		},
+	}
+
+	r := bufio.NewReader(conn)
+	if err := writePacket(conn, connect, connect.ConnectEncode); err != nil {
+		return err
+	}
+	connack, err := readPacket(r, version, packets.Connack)
+	if err != nil {
+		return err
+	}
+	if connack.ReasonCode != packets.CodeSuccess.Code {
+		return fmt.Errorf("connection refused by %s: reason code 0x%02x", b.Address, connack.ReasonCode)
+	}
+
+	pk.ProtocolVersion = version
+	pk.Mods.AllowResponseInfo = true // response topic and correlation data are only encoded with it
+	if pk.FixedHeader.Qos > 0 {
+		pk.PacketID = 1
+	}
+	if err := writePacket(conn, pk, pk.PublishEncode); err != nil {
+		return err
+	}
+
+	switch pk.FixedHeader.Qos {
+	case 1:
+		if err := readAck(r, version, packets.Puback); err != nil {
+			return err
+		}
+	case 2:
+		if err := readAck(r, version, packets.Pubrec); err != nil {
+			return err
+		}
+		pubrel := packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Pubrel, Qos: 1}, ProtocolVersion: version, PacketID: pk.PacketID}
+		if err := writePacket(conn, pubrel, pubrel.PubrelEncode); err != nil {
+			return err
+		}
+		if err := readAck(r, version, packets.Pubcomp); err != nil {
+			return err
+		}
+	}
+
+	disconnect := packets.Packet{FixedHeader: packets.FixedHeader{Type: packets.Disconnect}, ProtocolVersion: version}
+	return writePacket(conn, disconnect, disconnect.DisconnectEncode)
+}
+
+func writePacket(w io.Writer, pk packets.Packet, encode func(*bytes.Buffer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return fmt.Errorf("failed to encode %s packet: %w", packets.PacketNames[pk.FixedHeader.Type], err)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to send %s packet: %w", packets.PacketNames[pk.FixedHeader.Type], err)
+	}
+	return nil
+}
+
+// readPacket reads the next packet, which must be of type want.
+func readPacket(r *bufio.Reader, version byte, want byte) (packets.Packet, error) {
+	pk := packets.Packet{ProtocolVersion: version}
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return pk, fmt.Errorf("failed to read %s packet: %w", packets.PacketNames[want], err)
+	}
+	if err := pk.FixedHeader.Decode(b); err != nil {
+		return pk, fmt.Errorf("failed to decode %s packet: %w", packets.PacketNames[want], err)
+	}
+	if pk.FixedHeader.Remaining, _, err = packets.DecodeLength(r); err != nil {
+		return pk, fmt.Errorf("failed to decode %s packet: %w", packets.PacketNames[want], err)
+	}
+
+	body := make([]byte, pk.FixedHeader.Remaining)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return pk, fmt.Errorf("failed to read %s packet: %w", packets.PacketNames[want], err)
+	}
+
+	switch pk.FixedHeader.Type {
+	case want:
+	case packets.Disconnect:
+		pk.DisconnectDecode(body)
+		return pk, fmt.Errorf("disconnected by the broker: reason code 0x%02x", pk.ReasonCode)
+	default:
+		return pk, fmt.Errorf("expected a %s packet, got %s", packets.PacketNames[want], packets.PacketNames[pk.FixedHeader.Type])
+	}
+
+	switch want {
+	case packets.Connack:
+		err = pk.ConnackDecode(body)
+	case packets.Puback:
+		err = pk.PubackDecode(body)
+	case packets.Pubrec:
+		err = pk.PubrecDecode(body)
+	case packets.Pubcomp:
+		err = pk.PubcompDecode(body)
+	}
+	if err != nil {
+		return pk, fmt.Errorf("failed to decode %s packet: %w", packets.PacketNames[want], err)
+	}
+
+	return pk, nil
+}
+
+func readAck(r *bufio.Reader, version byte, want byte) error {
+	ack, err := readPacket(r, version, want)
+	if err != nil {
+		return err
+	}
+	if ack.ReasonCode >= packets.ErrUnspecifiedError.Code {
+		return fmt.Errorf("publish refused by the broker: reason code 0x%02x", ack.ReasonCode)
+	}
+	return nil
+}
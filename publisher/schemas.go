@@ -0,0 +1,127 @@
+package publisher
+
+import (
+	"database/sql"
+	"fmt"
+
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/jsonschema"
+	"mqtt-mochi-server/mqtttopic"
+)
+
+// EventSchemaViolation is sent to OnEvent when payload validation is on and a published payload breaks a schema.
+const EventSchemaViolation = "schema_violation"
+
+// SchemaViolation is a constraint of a bound schema that a payload breaks.
+type SchemaViolation struct {
+	SchemaID   int    `json:"schema_id"`
+	SchemaName string `json:"schema_name"`
+	Path       string `json:"path"`
+	Message    string `json:"message"`
+}
+
+type boundSchema struct {
+	db.JSONSchemaBinding
+	name   string
+	schema *jsonschema.Schema
+}
+
+// loadSchemas reads and compiles the bound schemas. Schemas that no longer compile are skipped and reported.
+func loadSchemas(dbConn *sql.DB) ([]boundSchema, []error, error) {
+	rows, err := db.ListBoundJSONSchemas(dbConn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var schemas []boundSchema
+	var invalid []error
+	for _, row := range rows {
+		schema, err := jsonschema.Compile(row.Schema.Schema)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("schema %d (%s): %w", row.Schema.ID, row.Schema.Name, err))
+			continue
+		}
+		schemas = append(schemas, boundSchema{JSONSchemaBinding: row.JSONSchemaBinding, name: row.Schema.Name, schema: schema})
+	}
+	return schemas, invalid, nil
+}
+
+// checkPayload validates payload against each schema bound to the message, or to a filter matching its topic.
+// A schema bound several ways is only checked once.
+func checkPayload(schemas []boundSchema, messageID *int, topic string, payload interface{}) []SchemaViolation {
+	violations := []SchemaViolation{}
+	checked := make(map[int]bool)
+	for _, s := range schemas {
+		if checked[s.SchemaID] {
+			continue
+		}
+
+		applies := s.MessageID != nil && messageID != nil && *s.MessageID == *messageID
+		if s.TopicFilter != nil {
+			applies = mqtttopic.Match(*s.TopicFilter, topic)
+		}
+		if !applies {
+			continue
+		}
+
+		checked[s.SchemaID] = true
+		for _, e := range s.schema.Validate(payload) {
+			violations = append(violations, SchemaViolation{SchemaID: s.SchemaID, SchemaName: s.name, Path: e.Path, Message: e.Message})
+		}
+	}
+	return violations
+}
+
+// ValidateMessage checks the payload a message would publish against the schemas bound to it, reading the
+// schemas from the database. messageID is nil for a message not created yet. The error wraps
+// ErrInvalidPayload when the payload can't be generated.
+func (m *Manager) ValidateMessage(messageID *int, topic string, payload interface{}) ([]SchemaViolation, error) {
+	if m.DB == nil {
+		return []SchemaViolation{}, nil
+	}
+
+	schemas, _, err := loadSchemas(m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JSON schemas: %w", err)
+	}
+	generators, _, err := loadGenerators(m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JSON schemas: %w", err)
+	}
+
+	msg := db.Message{Topic: topic, Payload: payload}
+	if messageID != nil {
+		msg.ID = *messageID
+	}
+	generated, err := m.payload(msg, generators)
+	if err != nil {
+		return nil, err
+	}
+	return checkPayload(schemas, messageID, topic, generated), nil
+}
+
+// SetValidatePayloads turns the validation of every published payload on or off. Violations are counted in
+// the status of each message and sent as events; the payload is published all the same.
+func (m *Manager) SetValidatePayloads(on bool) {
+	m.validate.Store(on)
+}
+
+// checkPublished validates a payload about to be published, counting and reporting its violations.
+func (m *Manager) checkPublished(msg db.Message, payload interface{}) {
+	m.mu.Lock()
+	schemas := m.schemas
+	m.mu.Unlock()
+
+	id := msg.ID
+	violations := checkPayload(schemas, &id, msg.Topic, payload)
+	if len(violations) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	m.violations[msg.ID]++
+	m.mu.Unlock()
+
+	m.Server.Log.Warn("Published payload breaks its JSON schema", "message_id", msg.ID, "topic", msg.Topic, "violations", len(violations))
+	m.event(EventSchemaViolation, map[string]interface{}{"message_id": msg.ID, "topic": msg.Topic, "violations": violations})
+}
@@ -0,0 +1,111 @@
+package publisher
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are available to the payloads of ad-hoc publishes, e.g. {"id":"{{uuid}}","temp":{{randFloat 18 25}}}.
+var templateFuncs = template.FuncMap{
+	"now":        func() string { return time.Now().UTC().Format(time.RFC3339Nano) },
+	"unix":       func() int64 { return time.Now().Unix() },
+	"unixMilli":  func() int64 { return time.Now().UnixMilli() },
+	"uuid":       uuid,
+	"randInt":    randInt,
+	"randFloat":  randFloat,
+	"randChoice": randChoice,
+}
+
+// Render executes text as a template.
+func Render(text string) (string, error) {
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse payload template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return "", fmt.Errorf("failed to render payload template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderValue renders the strings of a decoded JSON value, keeping its structure.
+func renderValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return Render(v)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := renderValue(item)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderValue(item)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return v, nil
+	}
+}
+
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// randInt returns an integer in [min, max].
+func randInt(min, max int64) (int64, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt: max %d is lower than min %d", max, min)
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(max-min+1))
+	if err != nil {
+		return 0, err
+	}
+	return min + n.Int64(), nil
+}
+
+// randFloat returns a number in [min, max), rounded to 2 decimals.
+func randFloat(min, max float64) (float64, error) {
+	if max < min {
+		return 0, fmt.Errorf("randFloat: max %g is lower than min %g", max, min)
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<53))
+	if err != nil {
+		return 0, err
+	}
+	f := min + (max-min)*float64(n.Int64())/(1<<53)
+	return float64(int64(f*100)) / 100, nil
+}
+
+func randChoice(choices ...interface{}) (interface{}, error) {
+	if len(choices) == 0 {
+		return nil, fmt.Errorf("randChoice: no choice given")
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(choices))))
+	if err != nil {
+		return nil, err
+	}
+	return choices[n.Int64()], nil
+}
@@ -0,0 +1,251 @@
+package reload
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+
+	"mqtt-mochi-server/broker"
+	server_config "mqtt-mochi-server/config"
+	"mqtt-mochi-server/db"
+	"mqtt-mochi-server/ws"
+)
+
+// debounce groups the several write events editors emit when saving a file into one reload
+const debounce = 500 * time.Millisecond
+
+// Report tells what a reload applied, what needs a restart to take effect, and what failed.
+type Report struct {
+	Trigger         string    `json:"trigger"`
+	Time            time.Time `json:"time"`
+	Applied         []string  `json:"applied"`
+	RestartRequired []string  `json:"restart_required"`
+	Errors          []string  `json:"errors"`
+}
+
+// Reloader re-reads the configuration files and applies safe changes to the running simulator: log level,
+// CORS origins, payload validation, seed data and the rules the broker auth ledger adds. Changes to listeners, storage
+// or the database are reported as requiring a restart.
+type Reloader struct {
+	Broker              *broker.Broker
+	DB                  *sql.DB
+	Hub                 *ws.Hub
+	RestartChan         chan struct{}
+	SetCORSOrigins      func([]string)
+	SetValidatePayloads func(bool)
+
+	mu           sync.Mutex
+	current      *server_config.Config
+	brokerConfig *broker.Config
+	timer        *time.Timer
+}
+
+// New starts from the configuration the simulator was started with.
+func New(current *server_config.Config) (*Reloader, error) {
+	// The configuration given to the broker is altered by mochi, so a pristine copy is kept to compare against
+	brokerConfig, _, err := broker.LoadConfig(current)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reloader{current: current, brokerConfig: brokerConfig}, nil
+}
+
+// Watch reloads whenever one of the configuration files changes.
+func (r *Reloader) Watch() error {
+	return r.current.Watch(r.schedule, broker.ConfigFile(r.current))
+}
+
+func (r *Reloader) schedule(file string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(debounce, func() {
+		r.Reload(file)
+	})
+}
+
+// Reload reads the configuration files again, applies what it can and reports the outcome in the log and to
+// the WS clients.
+func (r *Reloader) Reload(trigger string) Report {
+	r.mu.Lock()
+	report := r.reload(trigger)
+	r.mu.Unlock()
+
+	for _, applied := range report.Applied {
+		log.Printf("Configuration reload (%s): applied %s\n", trigger, applied)
+	}
+	for _, restart := range report.RestartRequired {
+		log.Printf("Configuration reload (%s): %s changed, restart required\n", trigger, restart)
+	}
+	for _, err := range report.Errors {
+		log.Printf("Configuration reload (%s): %s\n", trigger, err)
+	}
+	if len(report.Applied)+len(report.RestartRequired)+len(report.Errors) == 0 {
+		log.Printf("Configuration reload (%s): no change\n", trigger)
+	}
+
+	if r.Hub != nil {
+		r.Hub.BroadcastEvent("config_changed", report)
+	}
+
+	return report
+}
+
+func (r *Reloader) reload(trigger string) Report {
+	report := Report{Trigger: trigger, Time: time.Now(), Applied: []string{}, RestartRequired: []string{}, Errors: []string{}}
+
+	next, err := r.current.Reload()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to read configuration: %v", err))
+		return report
+	}
+
+	// Sections left as they were are the running values, so that the next reload compares against them
+	running := *r.current
+	running.Main_config = next.Main_config
+
+	if !reflect.DeepEqual(next.Server_DB, running.Server_DB) {
+		report.RestartRequired = append(report.RestartRequired, "server_db")
+	}
+	if !reflect.DeepEqual(next.MQTT, running.MQTT) {
+		report.RestartRequired = append(report.RestartRequired, "mqtt_broker")
+	}
+
+	r.reloadGeneral(next.General, &running.General, &report)
+
+	if !reflect.DeepEqual(next.MqttData, running.MqttData) {
+		if r.applySeed(next.MqttData, running.General.Seed_Mode, &report) {
+			running.MqttData = next.MqttData
+		}
+	}
+
+	running.Broker_Section = next.Broker_Section
+	r.reloadBroker(&running, &report)
+
+	*r.current = running
+	return report
+}
+
+func (r *Reloader) reloadGeneral(next server_config.General_Config, running *server_config.General_Config, report *Report) {
+	if next.DebugLevel != running.DebugLevel {
+		if err := r.Broker.SetLogLevel(next.DebugLevel); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("general.debug_level: %v", err))
+		} else {
+			running.DebugLevel = next.DebugLevel
+			report.Applied = append(report.Applied, "general.debug_level")
+		}
+	}
+
+	if !reflect.DeepEqual(next.Cors_Origins, running.Cors_Origins) {
+		if r.SetCORSOrigins != nil {
+			r.SetCORSOrigins(next.Cors_Origins)
+		}
+		running.Cors_Origins = next.Cors_Origins
+		report.Applied = append(report.Applied, "general.cors_origins")
+	}
+
+	if next.Seed_Mode != running.Seed_Mode {
+		running.Seed_Mode = next.Seed_Mode
+		report.Applied = append(report.Applied, "general.seed_mode")
+	}
+
+	if next.Validate_Payloads != running.Validate_Payloads {
+		if r.SetValidatePayloads != nil {
+			r.SetValidatePayloads(next.Validate_Payloads)
+		}
+		running.Validate_Payloads = next.Validate_Payloads
+		report.Applied = append(report.Applied, "general.validate_payloads")
+	}
+
+	// The remaining settings are only read at startup
+	rest, runningRest := next, *running
+	rest.DebugLevel, rest.Cors_Origins, rest.Seed_Mode, rest.Validate_Payloads = "", nil, "", false
+	runningRest.DebugLevel, runningRest.Cors_Origins, runningRest.Seed_Mode, runningRest.Validate_Payloads = "", nil, "", false
+	if !reflect.DeepEqual(rest, runningRest) {
+		report.RestartRequired = append(report.RestartRequired, "general")
+	}
+}
+
+func (r *Reloader) applySeed(entries []map[string]interface{}, mode string, report *Report) bool {
+	if r.DB == nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: database connection not available", r.current.DataFile()))
+		return false
+	}
+
+	seedReport, err := db.Seed(r.DB, entries, mode)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", r.current.DataFile(), err))
+		return false
+	}
+
+	report.Applied = append(report.Applied, fmt.Sprintf("%s (%s: %d created, %d updated, %d unchanged)", r.current.DataFile(), mode, seedReport.Created, seedReport.Updated, seedReport.Unchanged))
+	if seedReport.Created+seedReport.Updated > 0 && r.RestartChan != nil {
+		select {
+		case r.RestartChan <- struct{}{}:
+		default:
+		}
+	}
+
+	return true
+}
+
+func (r *Reloader) reloadBroker(running *server_config.Config, report *Report) {
+	next, source, err := broker.LoadConfig(running)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	current := r.brokerConfig
+	nextLedger, currentLedger := ledgerOf(next), ledgerOf(current)
+
+	// Everything but the ledger is only read at startup
+	nextRest, currentRest := *next, *current
+	nextRest.Hooks.Auth, currentRest.Hooks.Auth = authWithoutLedger(next), authWithoutLedger(current)
+	if !reflect.DeepEqual(nextRest, currentRest) {
+		report.RestartRequired = append(report.RestartRequired, source)
+	}
+
+	// The ledger is merged, so that users and ACL rules added through the API are kept
+	if !reflect.DeepEqual(nextLedger, currentLedger) {
+		users, acl, err := r.Broker.MergeLedger(currentLedger, nextLedger)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: hooks.auth.ledger: %v", source, err))
+			return
+		}
+		report.Applied = append(report.Applied, fmt.Sprintf("%s: hooks.auth.ledger (merge: %d users, %d ACL rules added)", source, users, acl))
+
+		updated := *current
+		if current.Hooks.Auth != nil {
+			authConfig := *current.Hooks.Auth
+			authConfig.Ledger = nextLedger
+			updated.Hooks.Auth = &authConfig
+		}
+		r.brokerConfig = &updated
+	}
+}
+
+func ledgerOf(c *broker.Config) *auth.Ledger {
+	if c.Hooks.Auth == nil {
+		return nil
+	}
+	return c.Hooks.Auth.Ledger
+}
+
+func authWithoutLedger(c *broker.Config) *broker.AuthHookConfig {
+	if c.Hooks.Auth == nil {
+		return nil
+	}
+	authConfig := *c.Hooks.Auth
+	authConfig.Ledger = nil
+	return &authConfig
+}
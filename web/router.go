@@ -4,11 +4,17 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 
+	"mqtt-mochi-server/broker"
 	"mqtt-mochi-server/middleware"
+	"mqtt-mochi-server/openapi"
+	"mqtt-mochi-server/publisher"
+	"mqtt-mochi-server/reload"
 	"mqtt-mochi-server/ws"
 )
 
@@ -17,6 +23,11 @@ type AppRouter struct {
 	DB          *sql.DB
 	WSHub       *ws.Hub
 	RestartChan chan struct{}
+	Broker      *broker.Broker
+	Reloader    *reload.Reloader
+	Publisher   *publisher.Manager
+
+	corsOrigins atomic.Pointer[[]string]
 }
 
 func NewRouter() *AppRouter {
@@ -46,17 +57,80 @@ func (ar *AppRouter) SetDB(db *sql.DB) {
 		Router:      ar.Router,
 		DB:          ar.DB,
 		RestartChan: ar.RestartChan,
+		Broker:      ar.Broker,
+		Reloader:    ar.Reloader,
+		Publisher:   ar.Publisher,
+		WSHub:       ar.WSHub,
 	}
 	ar.Router.Use(middleware.AppRouterInjector(middlewareAppRouter))
 }
 
 func (ar *AppRouter) SetupAPIV1Router(prefix string, s *mux.Router) {
+	spec, err := openapi.Load()
+	if err != nil {
+		log.Fatalf("Failed to load the OpenAPI document: %v", err)
+	}
+	s.Use(middleware.ValidateAPI(spec, prefix))
+
 	ar.Get(s, "/", middleware.GetIndex)
+	ar.Get(s, "/openapi.json", middleware.GetOpenAPI)
 	ar.Post(s, "/messages", middleware.PostMessage)
 	ar.Get(s, "/messages", middleware.GetMessages)
 	ar.Delete(s, "/messages/{id}", middleware.DeleteMessage)
 	ar.Put(s, "/messages/{id}", middleware.PutMessage)
 	ar.Get(s, "/messages/{id}", middleware.GetMessageByID)
+	ar.Get(s, "/messages/{id}/revisions", middleware.GetMessageRevisions)
+	ar.Post(s, "/messages/{id}/revisions/{rev}/restore", middleware.RestoreMessageRevision)
+	ar.Get(s, "/schemas", middleware.GetJSONSchemas)
+	ar.Post(s, "/schemas", middleware.PostJSONSchema)
+	ar.Get(s, "/schemas/{id}", middleware.GetJSONSchemaByID)
+	ar.Put(s, "/schemas/{id}", middleware.PutJSONSchema)
+	ar.Delete(s, "/schemas/{id}", middleware.DeleteJSONSchema)
+	ar.Post(s, "/schemas/{id}/validate", middleware.PostJSONSchemaValidate)
+	ar.Get(s, "/schemas/{id}/bindings", middleware.GetJSONSchemaBindings)
+	ar.Post(s, "/schemas/{id}/bindings", middleware.PostJSONSchemaBinding)
+	ar.Delete(s, "/schemas/{id}/bindings/{binding}", middleware.DeleteJSONSchemaBinding)
+	ar.Get(s, "/trash", middleware.GetTrash)
+	ar.Post(s, "/trash/{id}/restore", middleware.RestoreTrashedMessage)
+	ar.Get(s, "/history", middleware.GetHistory)
+	ar.Get(s, "/topics/tree", middleware.GetTopicTree)
+	ar.Post(s, "/publish", middleware.PostPublish)
+	ar.Get(s, "/subscribe", middleware.GetSubscribe)
+	ar.Get(s, "/wait", middleware.GetWait)
+	ar.Get(s, "/export", middleware.GetExport)
+	ar.Post(s, "/import", middleware.PostImport)
+	ar.Get(s, "/broker/users", middleware.GetBrokerUsers)
+	ar.Post(s, "/broker/users", middleware.PostBrokerUser)
+	ar.Get(s, "/broker/users/{id}", middleware.GetBrokerUserByID)
+	ar.Put(s, "/broker/users/{id}", middleware.PutBrokerUser)
+	ar.Delete(s, "/broker/users/{id}", middleware.DeleteBrokerUser)
+	ar.Get(s, "/broker/acl", middleware.GetBrokerACL)
+	ar.Post(s, "/broker/acl", middleware.PostBrokerACLRule)
+	ar.Put(s, "/broker/acl/{id}", middleware.PutBrokerACLRule)
+	ar.Delete(s, "/broker/acl/{id}", middleware.DeleteBrokerACLRule)
+	ar.Post(s, "/broker/ledger", middleware.PostBrokerLedger)
+	ar.Post(s, "/broker/wipe", middleware.PostBrokerWipe)
+	ar.Get(s, "/broker/clients", middleware.GetBrokerClients)
+	ar.Get(s, "/broker/clients/{id:.+}", middleware.GetBrokerClientByID)
+	ar.Delete(s, "/broker/clients/{id:.+}", middleware.DeleteBrokerClient)
+	ar.Get(s, "/broker/retained", middleware.GetBrokerRetained)
+	ar.Delete(s, "/broker/retained", middleware.DeleteBrokerRetained)
+	ar.Get(s, "/broker/retained/{topic:.+}", middleware.GetBrokerRetainedByTopic)
+	ar.Put(s, "/broker/retained/{topic:.+}", middleware.PutBrokerRetained)
+	ar.Delete(s, "/broker/retained/{topic:.+}", middleware.DeleteBrokerRetainedByTopic)
+	ar.Post(s, "/admin/reload", middleware.PostAdminReload)
+	ar.Get(s, "/ws/stats", middleware.GetWSStats)
+
+	s.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if spec.Route(method, strings.TrimPrefix(path, prefix)) == nil {
+				log.Printf("Route %s %s is missing from the OpenAPI document", method, path)
+			}
+		}
+		return nil
+	})
 
 	ar.Router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWs(ar.WSHub, w, r)
@@ -85,11 +159,30 @@ func NotFoundHandler() http.Handler {
 	})
 }
 
+// SetCORSOrigins replaces the origins allowed to call the API. "*" allows any origin.
+func (ar *AppRouter) SetCORSOrigins(origins []string) {
+	ar.corsOrigins.Store(&origins)
+}
+
+func (ar *AppRouter) allowOrigin(origin string) bool {
+	origins := ar.corsOrigins.Load()
+	if origins == nil {
+		return true
+	}
+
+	for _, o := range *origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
 func (ar *AppRouter) Run(port string) {
 	credentials := handlers.AllowCredentials()
-	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type"})
+	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "X-Author"})
 	methods := handlers.AllowedMethods([]string{"POST", "GET", "OPTIONS", "PUT", "DELETE"})
-	origins := handlers.AllowedOrigins([]string{"*"})
+	origins := handlers.AllowedOriginValidator(ar.allowOrigin)
 	handlers.MaxAge(86400)
 
 	go func() {
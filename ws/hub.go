@@ -1,24 +1,126 @@
 package ws
 
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"mqtt-mochi-server/mqtttopic"
+)
+
+// queueSize bounds the messages waiting for the hub. Past it, new messages are dropped rather than blocking
+// the publisher.
+const queueSize = 4096
+
 type Hub struct {
 	// Registered clients
 	clients map[*Client]bool
 
-	// Inbound messages from the clients
-	broadcast chan []byte
+	// Outbound messages, delivered to the clients whose filters match their topic
+	broadcast chan outbound
+
+	// Subscribe and unsubscribe requests from the clients
+	control chan request
 
 	// Register requests from the clients
 	register chan *Client
 
 	// Unregister requests from clients
 	unregister chan *Client
+
+	// Stats requests, answered by the hub
+	stats chan chan HubStats
+
+	// Replies to the commands of the clients, once run
+	replies chan reply
+
+	commands atomic.Pointer[CommandHandler]
+
+	queued  atomic.Uint64
+	dropped atomic.Uint64
+
+	// Clients disconnected for lagging behind, only touched by the hub
+	disconnected uint64
+}
+
+// CommandHandler runs the command op of a WS client, given the whole message, and returns the result of its reply.
+type CommandHandler func(op string, data []byte) (interface{}, error)
+
+type outbound struct {
+	topic string
+	data  []byte
+	// all delivers the message to every client, whatever its filters and lag policy, as a reply is
+	all bool
+}
+
+type request struct {
+	client *Client
+	op     controlMessage
+	data   []byte
+	err    error
+}
+
+type reply struct {
+	client *Client
+	data   []byte
+}
+
+// controlMessage is sent by WS clients to pick the topics they receive, e.g. {"op":"subscribe","filter":"site/+/temp"},
+// or to run a command. The optional id is sent back in the reply.
+type controlMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Op     string          `json:"op"`
+	Filter string          `json:"filter"`
+}
+
+type controlReply struct {
+	Kind    string          `json:"kind"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Op      string          `json:"op,omitempty"`
+	Filter  string          `json:"filter,omitempty"`
+	Filters []string        `json:"filters,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// WS_Event_Result is pushed to every client when something happens in the simulator.
+type WS_Event_Result struct {
+	Kind      string      `json:"kind"`
+	Event     string      `json:"event"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// HubStats counts what the hub delivered and dropped, overall and for each connected client.
+type HubStats struct {
+	Queued       uint64        `json:"queued"`
+	Dropped      uint64        `json:"dropped"`
+	QueueLength  int           `json:"queue_length"`
+	QueueSize    int           `json:"queue_size"`
+	Disconnected uint64        `json:"disconnected"`
+	Clients      []ClientStats `json:"clients"`
+}
+
+type ClientStats struct {
+	Remote    string   `json:"remote"`
+	Policy    string   `json:"policy"`
+	Filters   []string `json:"filters"`
+	Pending   int      `json:"pending"`
+	Delivered uint64   `json:"delivered"`
+	Dropped   uint64   `json:"dropped"`
 }
 
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan outbound, queueSize),
+		control:    make(chan request),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		stats:      make(chan chan HubStats),
+		replies:    make(chan reply),
 		clients:    make(map[*Client]bool),
 	}
 }
@@ -30,18 +132,195 @@ func (h *Hub) Run() {
 			h.clients[client] = true
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.drop(client)
+			}
+		case req := <-h.control:
+			if _, ok := h.clients[req.client]; ok {
+				h.handleControl(req)
+			}
+		case r := <-h.replies:
+			if _, ok := h.clients[r.client]; ok {
+				h.reply(r.client, r.data)
+			}
+		case reply := <-h.stats:
+			stats := HubStats{
+				Queued:       h.queued.Load(),
+				Dropped:      h.dropped.Load(),
+				QueueLength:  len(h.broadcast),
+				QueueSize:    cap(h.broadcast),
+				Disconnected: h.disconnected,
+				Clients:      []ClientStats{},
+			}
+			for client := range h.clients {
+				stats.Clients = append(stats.Clients, client.stats())
 			}
+			reply <- stats
 		case message := <-h.broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if message.all {
+					h.reply(client, message.data)
+				} else if client.wants(message.topic) {
+					h.deliver(client, message.data)
 				}
 			}
 		}
 	}
 }
+
+// enqueue hands a message to the hub without ever blocking.
+func (h *Hub) enqueue(message outbound) {
+	select {
+	case h.broadcast <- message:
+		h.queued.Add(1)
+	default:
+		h.dropped.Add(1)
+	}
+}
+
+// SetCommands sets what runs the commands of the clients, other than subscribe and unsubscribe.
+func (h *Hub) SetCommands(handler CommandHandler) {
+	h.commands.Store(&handler)
+}
+
+// BroadcastEvent pushes an event to every client.
+func (h *Hub) BroadcastEvent(event string, data interface{}) {
+	jsonMessage, err := json.Marshal(WS_Event_Result{Kind: "event", Event: event, Data: data, Timestamp: time.Now()})
+	if err != nil {
+		log.Printf("Error marshalling json in websocket: %s", err.Error())
+		return
+	}
+	h.enqueue(outbound{data: jsonMessage, all: true})
+}
+
+// Stats returns the hub counters.
+func (h *Hub) Stats() HubStats {
+	reply := make(chan HubStats, 1)
+	h.stats <- reply
+	return <-reply
+}
+
+func (h *Hub) drop(client *Client) {
+	delete(h.clients, client)
+	close(client.send)
+}
+
+// deliver queues data for the client, applying its policy when the client lags behind.
+func (h *Hub) deliver(client *Client, data []byte) {
+	if client.policy == PolicySample && len(client.send) >= cap(client.send)/2 {
+		client.sampled++
+		if client.sampled%client.sampleRate != 0 {
+			client.dropped++
+			return
+		}
+	}
+
+	select {
+	case client.send <- data:
+		client.delivered++
+		return
+	default:
+	}
+
+	switch client.policy {
+	case PolicyDisconnect:
+		h.drop(client)
+		h.disconnected++
+	case PolicyDropOldest:
+		select {
+		case <-client.send:
+			client.dropped++
+		default:
+		}
+		select {
+		case client.send <- data:
+			client.delivered++
+		default:
+			client.dropped++
+		}
+	default:
+		client.dropped++
+	}
+}
+
+// reply queues a reply or an event for the client, whatever its policy, since a client waiting for the reply
+// to a command would wait forever. A client that lets its replies pile up is not reading at all, and is
+// disconnected.
+func (h *Hub) reply(client *Client, data []byte) {
+	select {
+	case client.replies <- data:
+		client.delivered++
+	default:
+		h.drop(client)
+		h.disconnected++
+	}
+}
+
+func (h *Hub) handleControl(req request) {
+	client, op := req.client, req.op
+	reply := controlReply{Kind: "ack", ID: op.ID, Op: op.Op, Filter: op.Filter}
+
+	switch {
+	case req.err != nil:
+		reply = controlReply{Kind: "error", Error: req.err.Error()}
+	case op.Op == "subscribe":
+		if err := mqtttopic.ValidateFilter(op.Filter); err != nil {
+			reply = controlReply{Kind: "error", ID: op.ID, Op: op.Op, Filter: op.Filter, Error: err.Error()}
+			break
+		}
+		client.filters[op.Filter] = true
+	case op.Op == "unsubscribe":
+		delete(client.filters, op.Filter)
+	default:
+		if handler := h.commands.Load(); handler != nil {
+			go h.runCommand(*handler, req)
+			return
+		}
+		reply = controlReply{Kind: "error", ID: op.ID, Op: op.Op, Error: fmt.Sprintf("unknown op %q, expected subscribe or unsubscribe", op.Op)}
+	}
+
+	reply.Filters = client.filterList()
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Error marshalling json in websocket: %s", err.Error())
+		return
+	}
+	h.reply(client, data)
+}
+
+// runCommand runs a command outside of the hub, which then delivers the reply.
+func (h *Hub) runCommand(handler CommandHandler, req request) {
+	result, err := handler(req.op.Op, req.data)
+
+	cr := controlReply{Kind: "ack", ID: req.op.ID, Op: req.op.Op, Result: result}
+	if err != nil {
+		cr = controlReply{Kind: "error", ID: req.op.ID, Op: req.op.Op, Error: err.Error()}
+	}
+
+	data, err := json.Marshal(cr)
+	if err != nil {
+		log.Printf("Error marshalling json in websocket: %s", err.Error())
+		return
+	}
+	h.replies <- reply{client: req.client, data: data}
+}
+
+func (c *Client) filterList() []string {
+	filters := make([]string, 0, len(c.filters))
+	for filter := range c.filters {
+		filters = append(filters, filter)
+	}
+	sort.Strings(filters)
+	return filters
+}
+
+func (c *Client) stats() ClientStats {
+	return ClientStats{
+		Remote:    c.conn.RemoteAddr().String(),
+		Policy:    c.policy,
+		Filters:   c.filterList(),
+		Pending:   len(c.send),
+		Delivered: c.delivered,
+		Dropped:   c.dropped,
+	}
+}
@@ -0,0 +1,167 @@
+package ws
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestClient(policy string, buffer int) *Client {
+	return &Client{
+		send:       make(chan []byte, buffer),
+		replies:    make(chan []byte, buffer),
+		filters:    make(map[string]bool),
+		policy:     policy,
+		sampleRate: defaultSampleRate,
+	}
+}
+
+func pending(c *Client) []string {
+	var messages []string
+	for len(c.send) > 0 {
+		messages = append(messages, string(<-c.send))
+	}
+	return messages
+}
+
+func TestDeliver(t *testing.T) {
+	for _, c := range []struct {
+		policy       string
+		messages     int
+		delivered    uint64
+		dropped      uint64
+		disconnected uint64
+		pending      []string
+	}{
+		{policy: PolicyDropOldest, messages: 3, delivered: 3, pending: []string{"0", "1", "2"}},
+		{policy: PolicyDropOldest, messages: 6, delivered: 6, dropped: 2, pending: []string{"2", "3", "4", "5"}},
+		// Half of the buffer is filled, then one message out of sample_rate is kept
+		{policy: PolicySample, messages: 12, delivered: 3, dropped: 9, pending: []string{"0", "1", "11"}},
+		{policy: PolicySample, messages: 22, delivered: 4, dropped: 18, pending: []string{"0", "1", "11", "21"}},
+		{policy: PolicyDisconnect, messages: 4, delivered: 4, pending: []string{"0", "1", "2", "3"}},
+		{policy: PolicyDisconnect, messages: 5, delivered: 4, disconnected: 1, pending: []string{"0", "1", "2", "3"}},
+	} {
+		h := NewHub()
+		client := newTestClient(c.policy, 4)
+		h.clients[client] = true
+
+		for i := 0; i < c.messages; i++ {
+			if _, ok := h.clients[client]; ok {
+				h.deliver(client, []byte(fmt.Sprint(i)))
+			}
+		}
+
+		name := fmt.Sprintf("%s, %d messages", c.policy, c.messages)
+		if client.delivered != c.delivered || client.dropped != c.dropped || h.disconnected != c.disconnected {
+			t.Errorf("%s: delivered %d, dropped %d, disconnected %d, want %d, %d, %d", name,
+				client.delivered, client.dropped, h.disconnected, c.delivered, c.dropped, c.disconnected)
+		}
+		if got := pending(client); fmt.Sprint(got) != fmt.Sprint(c.pending) {
+			t.Errorf("%s: pending %v, want %v", name, got, c.pending)
+		}
+	}
+}
+
+// Replies and events are not subject to the policy, and a full buffer of messages doesn't hold them back
+func TestReply(t *testing.T) {
+	for _, policy := range []string{PolicyDropOldest, PolicySample} {
+		h := NewHub()
+		client := newTestClient(policy, 4)
+		h.clients[client] = true
+
+		for i := 0; i < 4; i++ {
+			h.deliver(client, []byte("message"))
+			h.reply(client, []byte(fmt.Sprint(i)))
+			h.deliver(client, []byte("message"))
+		}
+		if len(client.replies) != 4 || h.disconnected != 0 {
+			t.Errorf("%s: %d replies pending, disconnected %d, want 4 and 0", policy, len(client.replies), h.disconnected)
+		}
+	}
+
+	// A client that lets its replies pile up is disconnected, whatever its policy
+	for _, policy := range []string{PolicyDropOldest, PolicySample, PolicyDisconnect} {
+		h := NewHub()
+		client := newTestClient(policy, 4)
+		h.clients[client] = true
+
+		for i := 0; i < 4; i++ {
+			h.reply(client, []byte(fmt.Sprint(i)))
+		}
+		if _, ok := h.clients[client]; !ok || h.disconnected != 0 {
+			t.Fatalf("%s: client disconnected with room left for replies", policy)
+		}
+		h.reply(client, []byte("one too many"))
+		if _, ok := h.clients[client]; ok || h.disconnected != 1 {
+			t.Errorf("%s: client still connected after its replies piled up, disconnected %d", policy, h.disconnected)
+		}
+	}
+}
+
+func TestWants(t *testing.T) {
+	for _, c := range []struct {
+		filters []string
+		topic   string
+		want    bool
+	}{
+		{nil, "a/b", true},
+		{nil, "$SYS/broker", true},
+		{[]string{"a/b"}, "a/b", true},
+		{[]string{"a/b"}, "a/c", false},
+		{[]string{"a/+", "c/#"}, "c/d/e", true},
+		{[]string{"a/+", "c/#"}, "b", false},
+		{[]string{"#"}, "$SYS/broker", false},
+		{[]string{"$share/g/a/#"}, "a/b", true},
+	} {
+		client := newTestClient(PolicyDropOldest, 1)
+		for _, filter := range c.filters {
+			client.filters[filter] = true
+		}
+		if got := client.wants(c.topic); got != c.want {
+			t.Errorf("filters %v, topic %q: wants = %v, want %v", c.filters, c.topic, got, c.want)
+		}
+	}
+}
+
+// The hub routes messages by filter, and events to every client
+func TestRun(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	all, filtered := newTestClient(PolicyDropOldest, 16), newTestClient(PolicyDropOldest, 16)
+	filtered.filters["a/#"] = true
+	h.register <- all
+	h.register <- filtered
+
+	h.BroadcastMessage("a/b", 1)
+	h.BroadcastMessage("b", 2)
+	h.BroadcastEvent("config_changed", nil)
+
+	receive := func(c *Client, want int) []string {
+		var got []string
+		timeout := time.After(time.Second)
+		for len(got) < want {
+			select {
+			case m := <-c.send:
+				got = append(got, string(m))
+			case m := <-c.replies:
+				got = append(got, string(m))
+			case <-timeout:
+				return got
+			}
+		}
+		return got
+	}
+	if got := receive(all, 3); len(got) != 3 {
+		t.Errorf("client without filters received %v, want 3 messages", got)
+	}
+	if got := receive(filtered, 2); len(got) != 2 || !slices.Contains(got, `{"kind":"message","topic":"a/b","data":1}`) {
+		t.Errorf("client on a/# received %v, want a/b and the event", got)
+	}
+	select {
+	case m := <-filtered.send:
+		t.Errorf("client on a/# received %s", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -3,11 +3,15 @@ package ws
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"mqtt-mochi-server/mqtttopic"
 )
 
 const (
@@ -20,13 +24,12 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	// Maximum message size allowed from peer, large enough for the payloads of publish commands
+	maxMessageSize = 64 * 1024
 )
 
 var (
 	newline = []byte{'\n'}
-	space   = []byte{' '}
 )
 
 var upgrader = websocket.Upgrader{
@@ -52,6 +55,44 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+
+	// Replies and events, which lag policies don't apply to. They are written before pending messages.
+	replies chan []byte
+
+	// Topic filters of the client, only touched by the hub. Without any, the client receives everything.
+	filters map[string]bool
+
+	// What the hub does when the client lags behind, and its counters, only touched by the hub
+	policy     string
+	sampleRate uint64
+	sampled    uint64
+	delivered  uint64
+	dropped    uint64
+}
+
+// Policies for clients that do not keep up with the messages.
+const (
+	// PolicyDropOldest discards the oldest pending message to make room for the new one
+	PolicyDropOldest = "drop-oldest"
+	// PolicySample only keeps one message out of sample_rate once half of the buffer is pending
+	PolicySample = "sample"
+	// PolicyDisconnect closes the connection when the buffer is full
+	PolicyDisconnect = "disconnect"
+)
+
+const defaultSampleRate = 10
+
+func (c *Client) wants(topic string) bool {
+	if len(c.filters) == 0 {
+		return true
+	}
+
+	for filter := range c.filters {
+		if mqtttopic.Match(filter, topic) {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Client) ReadPump() {
@@ -70,8 +111,11 @@ func (c *Client) ReadPump() {
 			}
 			break
 		}
-		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		c.hub.broadcast <- message
+		req := request{client: c, data: bytes.TrimSpace(message)}
+		if err := json.Unmarshal(req.data, &req.op); err != nil {
+			req.err = fmt.Errorf("invalid control message: %w", err)
+		}
+		c.hub.control <- req
 	}
 }
 
@@ -83,26 +127,17 @@ func (c *Client) WritePump() {
 	}()
 	for {
 		select {
+		case message := <-c.replies:
+			if err := c.write(message); err != nil {
+				return
+			}
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			if err := c.write(message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -114,29 +149,102 @@ func (c *Client) WritePump() {
 	}
 }
 
+// write sends message along with the pending replies and messages, in one frame.
+func (c *Client) write(message []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	w.Write(message)
+
+	for _, pending := range []chan []byte{c.replies, c.send} {
+		n := len(pending)
+		for i := 0; i < n; i++ {
+			next, ok := <-pending
+			if !ok {
+				break
+			}
+			w.Write(newline)
+			w.Write(next)
+		}
+	}
+
+	return w.Close()
+}
+
+// ServeWs upgrades the connection. The policy and sample_rate query parameters pick what happens when the
+// client lags behind, drop-oldest by default.
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	policy := r.URL.Query().Get("policy")
+	switch policy {
+	case "":
+		policy = PolicyDropOldest
+	case PolicyDropOldest, PolicySample, PolicyDisconnect:
+	default:
+		http.Error(w, fmt.Sprintf("unknown policy %q, expected %s, %s or %s", policy, PolicyDropOldest, PolicySample, PolicyDisconnect), http.StatusBadRequest)
+		return
+	}
+
+	sampleRate := uint64(defaultSampleRate)
+	if rate := r.URL.Query().Get("sample_rate"); rate != "" {
+		n, err := strconv.ParseUint(rate, 10, 32)
+		if err != nil || n == 0 {
+			http.Error(w, fmt.Sprintf("invalid sample_rate %q, expected a positive integer", rate), http.StatusBadRequest)
+			return
+		}
+		sampleRate = n
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), replies: make(chan []byte, 64), filters: make(map[string]bool), policy: policy, sampleRate: sampleRate}
 	client.hub.register <- client
 
 	go client.WritePump()
 	go client.ReadPump()
 }
 
+// WS_json_Result is a message the simulator published.
 type WS_json_Result struct {
+	Kind string      `json:"kind"`
 	Type string      `json:"topic"`
 	Data interface{} `json:"data"`
 }
 
 func (h *Hub) BroadcastMessage(topic string, payload interface{}) {
-	message := WS_json_Result{Type: topic, Data: payload}
+	message := WS_json_Result{Kind: "message", Type: topic, Data: payload}
 	jsonMessage, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshalling json in websocket: %s", err.Error())
+		return
+	}
+	h.enqueue(outbound{topic: topic, data: jsonMessage})
+}
+
+// WS_Traffic_Result is a message seen by the broker, from a network client. Kind tells it apart from the
+// simulator's own WS_json_Result messages.
+type WS_Traffic_Result struct {
+	Kind      string      `json:"kind"`
+	Topic     string      `json:"topic"`
+	Data      interface{} `json:"data"`
+	Client    string      `json:"client"`
+	QoS       byte        `json:"qos"`
+	Retain    bool        `json:"retain"`
+	Size      int         `json:"size"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+func (h *Hub) BroadcastTraffic(traffic WS_Traffic_Result) {
+	traffic.Kind = "traffic"
+	jsonMessage, err := json.Marshal(traffic)
+	if err != nil {
+		log.Printf("Error marshalling json in websocket: %s", err.Error())
+		return
 	}
-	h.broadcast <- jsonMessage
+	h.enqueue(outbound{topic: traffic.Topic, data: jsonMessage})
 }